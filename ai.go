@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// FolderSuggester asks a model where a file should go.
+type FolderSuggester interface {
+	Suggest(ctx context.Context, filename, metadata, folders string) (string, error)
+}
+
+// BatchSuggester is implemented by backends that can classify several files
+// in one call.
+type BatchSuggester interface {
+	SuggestBatch(ctx context.Context, files []batchFile, folders string) (string, error)
+}
+
+type batchFile struct {
+	Filename string
+	Metadata string
+}
+
+// promptConfig holds everything shared between backends for building the
+// classification prompt.
+type promptConfig struct {
+	instructions string
+	knowledge    string
+	preserve     bool
+	allowed      []string
+}
+
+// constraint tells the model which folders it may answer with.
+func (p promptConfig) constraint() string {
+	switch {
+	case len(p.allowed) > 0:
+		return fmt.Sprintf("Only pick one of these folders, exactly as written: %s.", strings.Join(p.allowed, ", "))
+	case p.preserve:
+		return "Do not suggest new folders. Only pick from existing ones."
+	default:
+		return "You may suggest new folders if appropriate."
+	}
+}
+
+func (p promptConfig) build(filename, metadata, folders string) string {
+	prompt := fmt.Sprintf(`%s
+
+Knowledge base:
+%s
+
+Filename: %s
+Metadata: %s
+Existing folder structure: %s
+
+Constraints:
+- Respond only with JSON of the form {"folder": "<folder path>", "reason": "<one sentence>", "confidence": <number from 0 to 1>}.
+- %s`,
+		p.instructions,
+		p.knowledge,
+		filename,
+		metadata,
+		folders,
+		p.constraint(),
+	)
+
+	slog.Debug("Prompt:\n" + prompt)
+	return prompt
+}
+
+func (p promptConfig) buildBatch(files []batchFile, folders string) string {
+	var list strings.Builder
+	for i, f := range files {
+		fmt.Fprintf(&list, "%d. Filename: %s\n   Metadata: %s\n", i, f.Filename, f.Metadata)
+	}
+
+	prompt := fmt.Sprintf(`%s
+
+Knowledge base:
+%s
+
+Files:
+%s
+Existing folder structure: %s
+
+Constraints:
+- Respond only with JSON of the form {"results": [{"index": <file number>, "folder": "<folder path>", "reason": "<one sentence>", "confidence": <number from 0 to 1>}]}, with one entry per file.
+- %s`,
+		p.instructions,
+		p.knowledge,
+		list.String(),
+		folders,
+		p.constraint(),
+	)
+
+	slog.Debug("Prompt:\n" + prompt)
+	return prompt
+}
+
+func getGenAIClient(apiKey string) (*genai.Client, error) {
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GenAI client: %w", err)
+	}
+	return client, nil
+}
+
+// suggestionSchema constrains Gemini's output to the suggestion JSON.
+var suggestionSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"folder":     {Type: genai.TypeString, Description: "Folder path for the file"},
+		"reason":     {Type: genai.TypeString, Description: "Why the file belongs there"},
+		"confidence": {Type: genai.TypeNumber, Description: "Confidence from 0 to 1"},
+	},
+	Required:         []string{"folder", "reason", "confidence"},
+	PropertyOrdering: []string{"folder", "reason", "confidence"},
+}
+
+var batchSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"results": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"index":      {Type: genai.TypeInteger, Description: "Number of the file in the list"},
+					"folder":     suggestionSchema.Properties["folder"],
+					"reason":     suggestionSchema.Properties["reason"],
+					"confidence": suggestionSchema.Properties["confidence"],
+				},
+				Required:         []string{"index", "folder", "reason", "confidence"},
+				PropertyOrdering: []string{"index", "folder", "reason", "confidence"},
+			},
+		},
+	},
+	Required: []string{"results"},
+}
+
+type geminiSuggester struct {
+	client *genai.Client
+	model  string
+	prompt promptConfig
+}
+
+func (g *geminiSuggester) Suggest(ctx context.Context, filename, metadata, folders string) (string, error) {
+	return g.generate(ctx, g.prompt.build(filename, metadata, folders), suggestionSchema)
+}
+
+func (g *geminiSuggester) SuggestBatch(ctx context.Context, files []batchFile, folders string) (string, error) {
+	return g.generate(ctx, g.prompt.buildBatch(files, folders), batchSchema)
+}
+
+func (g *geminiSuggester) generate(ctx context.Context, prompt string, schema *genai.Schema) (string, error) {
+	return withRetry(ctx, func() (string, error) {
+		resp, err := g.client.Models.GenerateContent(ctx, g.model, genai.Text(prompt), &genai.GenerateContentConfig{
+			ResponseMIMEType: "application/json",
+			ResponseSchema:   schema,
+		})
+		if err != nil {
+			return "", err
+		}
+		return resp.Text(), nil
+	})
+}
+
+const (
+	openAIURL          = "https://api.openai.com/v1/chat/completions"
+	defaultOpenAIModel = "gpt-4o-mini"
+)
+
+type openAISuggester struct {
+	apiKey string
+	model  string
+	prompt promptConfig
+	client *http.Client
+}
+
+// httpStatusError is returned for non-2xx responses from HTTP backends.
+type httpStatusError struct {
+	Code int
+	Body string
+}
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.Code, e.Body)
+}
+
+func (o *openAISuggester) Suggest(ctx context.Context, filename, metadata, folders string) (string, error) {
+	prompt := o.prompt.build(filename, metadata, folders)
+	return withRetry(ctx, func() (string, error) {
+		return o.complete(ctx, prompt)
+	})
+}
+
+func (o *openAISuggester) SuggestBatch(ctx context.Context, files []batchFile, folders string) (string, error) {
+	prompt := o.prompt.buildBatch(files, folders)
+	return withRetry(ctx, func() (string, error) {
+		return o.complete(ctx, prompt)
+	})
+}
+
+func (o *openAISuggester) complete(ctx context.Context, prompt string) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"model": o.model,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", httpStatusError{Code: resp.StatusCode, Body: string(data)}
+	}
+
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", fmt.Errorf("invalid OpenAI response: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return "", nil
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+// newSuggester builds the backend selected by cfg.Provider.
+func newSuggester(cfg GptConfig, knowledge string, preserve bool) (FolderSuggester, error) {
+	prompt := promptConfig{
+		instructions: cfg.Instructions,
+		knowledge:    knowledge,
+		preserve:     preserve,
+		allowed:      cfg.AllowedFolders,
+	}
+
+	switch cfg.Provider {
+	case "openai":
+		model := cfg.Model
+		if model == "" {
+			model = defaultOpenAIModel
+		}
+		return &openAISuggester{apiKey: cfg.ApiKey, model: model, prompt: prompt, client: http.DefaultClient}, nil
+	default:
+		client, err := getGenAIClient(cfg.ApiKey)
+		if err != nil {
+			return nil, err
+		}
+		return &geminiSuggester{client: client, model: cfg.Model, prompt: prompt}, nil
+	}
+}
+
+// suggestFolderWithGenAI starts workers goroutines that answer jobs from
+// jobQueue. They share the rate limiter and cache; each job carries its own
+// result channel, so answers always reach the caller that asked. An empty
+// answer means the AI couldn't help and the caller should fall back.
+func suggestFolderWithGenAI(ctx context.Context, suggester FolderSuggester, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	for range workers {
+		go aiWorker(ctx, suggester)
+	}
+}
+
+// suggestion is the model's parsed answer.
+type suggestion struct {
+	Folder     string  `json:"folder"`
+	Reason     string  `json:"reason"`
+	Confidence float64 `json:"confidence"`
+}
+
+// trimCodeFence strips the Markdown code fence models like to wrap JSON in.
+func trimCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	return strings.TrimSpace(text)
+}
+
+// parseSuggestion decodes the model's JSON answer, tolerating Markdown code
+// fences around it. A bare single-line answer is taken as the folder with
+// unknown (zero) confidence, so it only survives when no threshold is set.
+func parseSuggestion(text string) (suggestion, error) {
+	text = trimCodeFence(text)
+
+	var s suggestion
+	if err := json.Unmarshal([]byte(text), &s); err == nil {
+		s.Folder = strings.TrimSpace(s.Folder)
+		return s, nil
+	}
+	if text != "" && !strings.ContainsAny(text, "\n{}") {
+		return suggestion{Folder: text}, nil
+	}
+	return suggestion{}, fmt.Errorf("unexpected AI response %q", text)
+}
+
+type batchSuggestion struct {
+	Index int `json:"index"`
+	suggestion
+}
+
+// parseBatchSuggestion decodes the model's answer to a batch prompt.
+func parseBatchSuggestion(text string) ([]batchSuggestion, error) {
+	var out struct {
+		Results []batchSuggestion `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(trimCodeFence(text)), &out); err != nil {
+		return nil, fmt.Errorf("unexpected AI batch response %q", text)
+	}
+	for i := range out.Results {
+		out.Results[i].Folder = strings.TrimSpace(out.Results[i].Folder)
+	}
+	return out.Results, nil
+}
+
+// aiWorker answers jobs from jobQueue until ctx is cancelled. ctx is also
+// passed to the API calls, so a pending request is aborted on shutdown.
+// Each job uses the current aiConfig, so reloaded settings apply to it.
+func aiWorker(ctx context.Context, suggester FolderSuggester) {
+	for {
+		var job Job
+		select {
+		case job = <-jobQueue:
+		case <-ctx.Done():
+			return
+		}
+		config := *aiConfig.Load()
+		cfg := config.Gpt
+		if aiDisabled.Load() {
+			job.resultCh <- ""
+			continue
+		}
+		if answerFromCache(job) {
+			continue
+		}
+
+		batch := []Job{job}
+		batcher, canBatch := suggester.(BatchSuggester)
+		if canBatch && cfg.BatchSize > 1 {
+			batch = collectBatch(ctx, batch, cfg)
+		}
+
+		waitStart := time.Now()
+		err := limiter.Wait(ctx)
+		metrics.observeLimiterWait(time.Since(waitStart))
+		if err != nil {
+			slog.Warn(fmt.Sprint("Rate limiter error: ", err))
+			for _, job := range batch {
+				job.resultCh <- ""
+			}
+			continue
+		}
+
+		folders := getFolderStructure(config.Options.OutputDir, config.Ignore, cfg.MaxFolderDepth, cfg.MaxFolders)
+		if len(batch) > 1 {
+			suggestBatch(ctx, batcher, batch, folders, config)
+			continue
+		}
+
+		metadata := jobMetadata(job, cfg)
+		start := time.Now()
+		text, err := suggester.Suggest(ctx, filepath.Base(job.filename), metadata, folders)
+		metrics.observeAICall(time.Since(start), err)
+		runStats.recordAICall(err)
+		if err != nil {
+			reportAIError(err, job.filename, cfg)
+			job.resultCh <- ""
+			continue
+		}
+
+		answer, err := parseSuggestion(text)
+		if err != nil {
+			slog.Warn(err.Error())
+			job.resultCh <- ""
+			continue
+		}
+		deliver(job, answer, folders, config)
+	}
+}
+
+// answerFromCache replies to job from the suggestion cache if possible.
+func answerFromCache(job Job) bool {
+	target, ok := aiCache.get(job.filename)
+	if ok {
+		log.Println("Using cached AI suggestion for", filepath.Base(job.filename))
+		job.resultCh <- target
+	}
+	return ok
+}
+
+// deliver sends the answer's folder to the job's caller, or "" when the
+// model gave no folder or wasn't confident enough. With allowed_folders set
+// the folder must be one of them; otherwise, with preserve_structure on, it
+// is replaced by the closest existing one.
+func deliver(job Job, answer suggestion, folders string, config Config) {
+	cfg := config.Gpt
+	if answer.Folder == "" {
+		job.resultCh <- ""
+		return
+	}
+	folder, ok := sanitizeFolder(answer.Folder, config.Options.OutputDir)
+	if !ok {
+		slog.Warn(fmt.Sprintf("Rejected AI suggestion %q for %s, falling back", answer.Folder, filepath.Base(job.filename)),
+			"event", "ai_rejected", "src", job.filename, "ai_suggestion", answer.Folder)
+		job.resultCh <- ""
+		return
+	}
+	answer.Folder = folder
+	if len(cfg.AllowedFolders) > 0 {
+		allowed := allowedFolder(answer.Folder, cfg.AllowedFolders)
+		if allowed == "" {
+			slog.Info(fmt.Sprintf("AI suggested %s for %s, which isn't in allowed_folders, falling back", answer.Folder, filepath.Base(job.filename)),
+				"event", "ai_rejected", "src", job.filename, "ai_suggestion", answer.Folder)
+			job.resultCh <- ""
+			return
+		}
+		answer.Folder = allowed
+	} else if config.Options.PreserveStructure {
+		existing := closestExistingFolder(answer.Folder, config.Options.OutputDir, folders, cfg.FuzzyDistance)
+		if existing == "" {
+			slog.Info(fmt.Sprintf("AI suggested %s for %s, which doesn't exist, falling back", answer.Folder, filepath.Base(job.filename)),
+				"event", "ai_unknown_folder", "src", job.filename, "ai_suggestion", answer.Folder)
+			job.resultCh <- ""
+			return
+		}
+		if existing != answer.Folder {
+			log.Printf("AI suggested %s, using existing folder %s", answer.Folder, existing)
+			answer.Folder = existing
+		}
+	} else if _, err := os.Stat(filepath.Join(config.Options.OutputDir, answer.Folder)); err != nil {
+		// a near miss of an existing folder shouldn't create a new one
+		if similar := similarFolder(answer.Folder, folders, cfg.FuzzyDistance); similar != "" && similar != answer.Folder {
+			log.Printf("AI suggested %s, using existing folder %s", answer.Folder, similar)
+			answer.Folder = similar
+		}
+	}
+	slog.Debug(fmt.Sprintf("AI reasoning for %s → %s (confidence %.2f): %s",
+		filepath.Base(job.filename), answer.Folder, answer.Confidence, answer.Reason),
+		"event", "ai_reason", "src", job.filename, "ai_suggestion", answer.Folder,
+		"reason", answer.Reason, "confidence", answer.Confidence)
+	if answer.Confidence < cfg.MinConfidence {
+		slog.Info(fmt.Sprintf("AI confidence %.2f for %s → %s is below %.2f, falling back",
+			answer.Confidence, filepath.Base(job.filename), answer.Folder, cfg.MinConfidence),
+			"event", "ai_low_confidence", "src", job.filename, "ai_suggestion", answer.Folder, "confidence", answer.Confidence)
+		job.resultCh <- ""
+		return
+	}
+
+	aiCache.put(job.filename, answer.Folder)
+	job.resultCh <- answer.Folder
+}
+
+// illegalPathChars can't appear in file names on Windows, so they're dropped
+// everywhere to keep the output folder portable.
+const illegalPathChars = `<>:"|?*`
+
+// sanitizeFolder turns a model-suggested folder into a relative path that
+// stays inside outputDir. Leading slashes, drive letters, "." and ".."
+// segments and illegal characters are removed; ok is false if nothing
+// usable is left.
+func sanitizeFolder(folder, outputDir string) (string, bool) {
+	folder = strings.ReplaceAll(folder, `\`, "/")
+	if len(folder) >= 2 && folder[1] == ':' {
+		folder = folder[2:]
+	}
+
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		part = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f || strings.ContainsRune(illegalPathChars, r) {
+				return -1
+			}
+			return r
+		}, part)
+		// Windows also drops trailing dots and spaces
+		part = strings.TrimRight(strings.TrimSpace(part), ". ")
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+
+	clean := filepath.Join(parts...)
+	rel, err := filepath.Rel(outputDir, filepath.Join(outputDir, clean))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", false
+	}
+	return clean, true
+}
+
+// similarFolder returns the listed folder closest to suggested once both
+// are normalized: case, spaces, "-" and "_" are ignored, and at most
+// maxDistance edits are allowed. It returns "" when nothing is close
+// enough, there's a tie, or maxDistance is negative.
+func similarFolder(suggested, folders string, maxDistance int) string {
+	if maxDistance < 0 {
+		return ""
+	}
+	want := normalizeFolder(suggested)
+	best, bestDistance, tie := "", maxDistance+1, false
+	for _, folder := range strings.Split(strings.TrimSpace(folders), "\n") {
+		if folder == "" {
+			continue
+		}
+		d := levenshtein(normalizeFolder(folder), want)
+		switch {
+		case d < bestDistance:
+			best, bestDistance, tie = folder, d, false
+		case d == bestDistance:
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return best
+}
+
+// normalizeFolder lowercases folder and drops the characters models and
+// people use interchangeably in names, keeping the separators.
+func normalizeFolder(folder string) string {
+	folder = strings.ToLower(filepath.ToSlash(strings.TrimSpace(folder)))
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '_' {
+			return -1
+		}
+		return r
+	}, folder)
+}
+
+// levenshtein counts the single-rune insertions, deletions and
+// substitutions that turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// allowedFolder returns the entry of allowed that folder names, ignoring
+// case and slash direction, or "" if there's none.
+func allowedFolder(folder string, allowed []string) string {
+	for _, a := range allowed {
+		if strings.EqualFold(filepath.ToSlash(filepath.Clean(a)), filepath.ToSlash(folder)) {
+			return filepath.Clean(a)
+		}
+	}
+	return ""
+}
+
+// closestExistingFolder maps a suggested folder onto one that exists under
+// outputDir: the folder itself, a case-insensitive match from folders, the
+// only listed folder with the same name, a similar one (see similarFolder)
+// or its deepest existing parent. It returns "" if none of those exist.
+func closestExistingFolder(suggested, outputDir, folders string, maxDistance int) string {
+	suggested = filepath.Clean(strings.Trim(filepath.FromSlash(suggested), string(filepath.Separator)))
+	if info, err := os.Stat(filepath.Join(outputDir, suggested)); err == nil && info.IsDir() {
+		return suggested
+	}
+
+	var sameName []string
+	for _, folder := range strings.Split(strings.TrimSpace(folders), "\n") {
+		if folder == "" {
+			continue
+		}
+		if strings.EqualFold(folder, suggested) {
+			return folder
+		}
+		if strings.EqualFold(filepath.Base(folder), filepath.Base(suggested)) {
+			sameName = append(sameName, folder)
+		}
+	}
+	if len(sameName) == 1 {
+		return sameName[0]
+	}
+	if similar := similarFolder(suggested, folders, maxDistance); similar != "" {
+		return similar
+	}
+
+	for dir := filepath.Dir(suggested); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if info, err := os.Stat(filepath.Join(outputDir, dir)); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// collectBatch adds queued jobs to batch until it holds batch_size jobs or
+// batch_window has passed since the first one.
+func collectBatch(ctx context.Context, batch []Job, cfg GptConfig) []Job {
+	window := time.NewTimer(cfg.batchWindow)
+	defer window.Stop()
+
+	for len(batch) < cfg.BatchSize {
+		select {
+		case job := <-jobQueue:
+			if !answerFromCache(job) {
+				batch = append(batch, job)
+			}
+		case <-window.C:
+			return batch
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// jobMetadata describes the job's file for the prompt, including the hints
+// of the rules it matched.
+func jobMetadata(job Job, cfg GptConfig) string {
+	metadata := getFileMetadata(job.filename, cfg)
+	if job.hint != "" {
+		metadata += "; hint: " + job.hint
+	}
+	return metadata
+}
+
+// suggestBatch asks for all jobs in one call and fans the answers back out.
+// Jobs the model didn't answer fall back.
+func suggestBatch(ctx context.Context, batcher BatchSuggester, batch []Job, folders string, config Config) {
+	files := make([]batchFile, len(batch))
+	for i, job := range batch {
+		files[i] = batchFile{Filename: filepath.Base(job.filename), Metadata: jobMetadata(job, config.Gpt)}
+	}
+
+	start := time.Now()
+	text, err := batcher.SuggestBatch(ctx, files, folders)
+	metrics.observeAICall(time.Since(start), err)
+	runStats.recordAICall(err)
+	var answers []batchSuggestion
+	if err != nil {
+		reportAIError(err, "", config.Gpt)
+	} else if answers, err = parseBatchSuggestion(text); err != nil {
+		slog.Warn(err.Error())
+	}
+
+	byIndex := make(map[int]suggestion)
+	for _, a := range answers {
+		if a.Index >= 0 && a.Index < len(batch) {
+			byIndex[a.Index] = a.suggestion
+		}
+	}
+	if err == nil && len(answers) != len(batch) {
+		slog.Warn(fmt.Sprintf("AI answered %d of %d files in batch", len(byIndex), len(batch)))
+	}
+
+	for i, job := range batch {
+		answer, ok := byIndex[i]
+		if !ok {
+			job.resultCh <- ""
+			continue
+		}
+		deliver(job, answer, folders, config)
+	}
+}
+
+const (
+	aiAttempts    = 3
+	aiBackoffBase = time.Second
+)
+
+// withRetry calls fn, retrying transient failures with exponential backoff
+// and jitter.
+func withRetry(ctx context.Context, fn func() (string, error)) (string, error) {
+	backoff := aiBackoffBase
+	for attempt := 1; ; attempt++ {
+		text, err := fn()
+		if err == nil || attempt == aiAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return text, err
+		}
+
+		wait := backoff + rand.N(backoff/2)
+		slog.Warn(fmt.Sprintf("AI error (attempt %d/%d), retrying in %v: %v", attempt, aiAttempts, wait.Round(time.Millisecond), err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// aiDisabled is set after a fatal AI error with on_fatal_error "disable";
+// files then skip the AI and fall back.
+var aiDisabled atomic.Bool
+
+// reportAIError logs a failed AI call for src ("" for a batch). Fatal
+// errors, like a bad API key, fail every call the same way, so unless
+// on_fatal_error is "continue" the AI is turned off for the rest of the run.
+func reportAIError(err error, src string, cfg GptConfig) {
+	if !isFatalAIError(err) {
+		slog.Error(fmt.Sprint("GenAI error: ", err), "event", "ai_error", "src", src, "error", err)
+		return
+	}
+	if cfg.OnFatalError == "continue" {
+		slog.Error(fmt.Sprintf("GenAI error, check gpt.api_key and gpt.model: %v", err), "event", "ai_error", "src", src, "error", err)
+		return
+	}
+	if aiDisabled.CompareAndSwap(false, true) {
+		slog.Error(fmt.Sprintf("Disabling the AI for the rest of the run, files will go to the fallback folder. Check gpt.api_key and gpt.model: %v", err),
+			"event", "ai_disabled", "src", src, "error", err)
+	}
+}
+
+// isFatalAIError reports whether err comes from the configuration rather
+// than the file: a rejected API key, missing permissions or an unknown model.
+func isFatalAIError(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		case http.StatusBadRequest:
+			// Gemini answers a bad key with 400 API_KEY_INVALID
+			return strings.Contains(apiErr.Message, "API key")
+		}
+		return false
+	}
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryable reports whether err is worth another attempt: rate limits,
+// server errors and timeouts. Anything else, like a bad API key, is fatal.
+func isRetryable(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+// fakeSuggester always gives the same answer.
+type fakeSuggester string
+
+func (f fakeSuggester) Suggest(ctx context.Context, filename, metadata, folders string) (string, error) {
+	return string(f), nil
+}
+
+func TestAIWorkerUsesReloadedConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.pdf")
+	writeFile(t, file, "report")
+
+	prevQueue, prevLimiter := jobQueue, limiter
+	jobQueue, limiter = make(chan Job), rate.NewLimiter(rate.Inf, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		jobQueue, limiter = prevQueue, prevLimiter
+	})
+
+	config := Config{Options: Options{OutputDir: dir}, Gpt: GptConfig{MinConfidence: 0.9}}
+	aiConfig.Store(&config)
+	go func() {
+		aiWorker(ctx, fakeSuggester(`{"folder": "Work", "confidence": 0.5}`))
+		close(done)
+	}()
+
+	ask := func() string {
+		resultCh := make(chan string, 1)
+		jobQueue <- Job{filename: file, resultCh: resultCh}
+		return <-resultCh
+	}
+	if got := ask(); got != "" {
+		t.Fatalf("answer below min_confidence = %q, want fallback", got)
+	}
+	reloaded := config
+	reloaded.Gpt.MinConfidence = 0.1
+	aiConfig.Store(&reloaded)
+	if got := ask(); got != "Work" {
+		t.Errorf("answer after lowering min_confidence = %q, want Work", got)
+	}
+}
+
+func TestSanitizeFolder(t *testing.T) {
+	out := t.TempDir()
+	tests := []struct {
+		name, folder, want string
+		ok                 bool
+	}{
+		{"plain", "Work/Reports", "Work/Reports", true},
+		{"parent segments", "../../etc/passwd", "etc/passwd", true},
+		{"parent in the middle", "Work/../../Reports", "Work/Reports", true},
+		{"absolute", "/etc/cron.d", "etc/cron.d", true},
+		{"drive letter", `C:\Users\me\Documents`, "Users/me/Documents", true},
+		{"illegal characters", `Wo<r>k:/"Re|p?o*rts"`, "Work/Reports", true},
+		{"control characters", "Work\x00\n/Re\x7fports\t", "Work/Reports", true},
+		{"trailing dots and spaces", "Work. . /Reports...", "Work/Reports", true},
+		{"only parents", "../..", "", false},
+		{"only dots", "./.././...", "", false},
+		{"empty", "", "", false},
+		{"only illegal characters", `<>:"|?*`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := sanitizeFolder(tt.folder, out)
+			if got != filepath.FromSlash(tt.want) || ok != tt.ok {
+				t.Errorf("sanitizeFolder(%q) = %q, %v; want %q, %v", tt.folder, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
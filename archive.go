@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// archiveSampleNames is how many entry names are quoted in the summary.
+const archiveSampleNames = 5
+
+// describeZip summarizes what a zip file holds from its central directory,
+// without extracting anything, e.g. `12 files: 10 .jpg, 2 .txt; e.g.
+// "IMG_001.jpg"`. Only the first maxEntries entries are looked at.
+func describeZip(name string, maxEntries int) string {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+
+	counts := make(map[string]int)
+	var files int
+	var samples []string
+	for i, f := range r.File {
+		if maxEntries > 0 && i >= maxEntries {
+			break
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files++
+		ext := strings.ToLower(path.Ext(f.Name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		counts[ext]++
+		if len(samples) < archiveSampleNames {
+			samples = append(samples, fmt.Sprintf("%q", path.Base(f.Name)))
+		}
+	}
+	if files == 0 {
+		return "empty archive"
+	}
+
+	exts := make([]string, 0, len(counts))
+	for ext := range counts {
+		exts = append(exts, ext)
+	}
+	// most common first
+	sort.Slice(exts, func(i, j int) bool {
+		if counts[exts[i]] != counts[exts[j]] {
+			return counts[exts[i]] > counts[exts[j]]
+		}
+		return exts[i] < exts[j]
+	})
+	parts := make([]string, len(exts))
+	for i, ext := range exts {
+		parts[i] = fmt.Sprintf("%d %s", counts[ext], ext)
+	}
+
+	summary := fmt.Sprintf("%d files: %s; e.g. %s", files, strings.Join(parts, ", "), strings.Join(samples, ", "))
+	if maxEntries > 0 && len(r.File) > maxEntries {
+		summary = fmt.Sprintf("%d entries, first %d: %s", len(r.File), maxEntries, summary)
+	}
+	return summary
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time
+// isn't easily available.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	Target  string    `json:"target"`
+	Expires time.Time `json:"expires"`
+}
+
+// suggestionCache remembers AI suggestions for similar filenames so they
+// don't cost another API call. It is optionally persisted to a JSON file.
+type suggestionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	path    string
+	entries map[string]cacheEntry
+}
+
+var (
+	digitRun = regexp.MustCompile(`[0-9]+`)
+	sepRun   = regexp.MustCompile(`[\s_\-.()\[\]]+`)
+)
+
+func newSuggestionCache(ttl time.Duration, path string) *suggestionCache {
+	c := &suggestionCache{ttl: ttl, path: path, entries: make(map[string]cacheEntry)}
+	if path == "" {
+		return c
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("Could not read AI cache %s: %v", path, err))
+		}
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		slog.Warn(fmt.Sprintf("Ignoring invalid AI cache %s: %v", path, err))
+		c.entries = make(map[string]cacheEntry)
+	}
+	return c
+}
+
+// cacheKey normalizes a filename so "IMG_2041.JPG" and "img-2042.jpg" share
+// an entry: lowercase, digit runs collapsed, separators unified.
+func cacheKey(filename string) string {
+	base := strings.ToLower(filepath.Base(filename))
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	name = digitRun.ReplaceAllString(name, "0")
+	name = strings.Trim(sepRun.ReplaceAllString(name, " "), " ")
+	return ext + "|" + name
+}
+
+func (c *suggestionCache) get(filename string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(filename)
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.Expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.Target, true
+}
+
+func (c *suggestionCache) put(filename, target string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[cacheKey(filename)] = cacheEntry{Target: target, Expires: time.Now().Add(c.ttl)}
+	c.save()
+}
+
+// clear drops every entry, including the ones on disk.
+func (c *suggestionCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cacheEntry)
+	c.save()
+}
+
+// save writes the cache to disk. The caller must hold c.mu.
+func (c *suggestionCache) save() {
+	if c.path == "" {
+		return
+	}
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.Expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not encode AI cache: %v", err))
+		return
+	}
+	if err := os.WriteFile(c.path, data, 0o644); err != nil {
+		slog.Warn(fmt.Sprintf("Could not write AI cache %s: %v", c.path, err))
+	}
+}
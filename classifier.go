@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClassifierConfig runs an external command to pick a target folder for
+// files no rule matched.
+type ClassifierConfig struct {
+	Command []string `yaml:"command"` // program and arguments
+	Timeout string   `yaml:"timeout"`
+	Order   string   `yaml:"order"` // before_ai (default) or after_ai
+
+	timeout time.Duration
+}
+
+// classifierInput is written to the command's stdin as JSON.
+type classifierInput struct {
+	Path     string    `json:"path"`
+	Filename string    `json:"filename"`
+	Mime     string    `json:"mime"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Metadata string    `json:"metadata"`
+}
+
+// classify asks the classifier command for a folder for path. The first
+// non-empty line of its output is the folder, sanitized like AI
+// suggestions; no output, a failure or a timeout give "".
+func classify(ctx context.Context, path string, config Config) string {
+	name := filepath.Base(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	input, err := json.Marshal(classifierInput{
+		Path:     path,
+		Filename: name,
+		Mime:     detectContentType(path),
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+		Metadata: getFileMetadata(path, config.Gpt),
+	})
+	if err != nil {
+		return ""
+	}
+
+	outer := ctx
+	ctx, cancel := context.WithTimeout(ctx, config.Classifier.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, config.Classifier.Command[0], config.Classifier.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	// don't wait for children of a killed command that still hold stdout
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if outer.Err() != nil {
+		// shutting down or file_timeout, reported by the caller
+		return ""
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		slog.Warn(fmt.Sprintf("Classifier timed out on %s after %v", name, config.Classifier.timeout))
+		return ""
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Classifier failed on %s: %v", name, err))
+		return ""
+	}
+
+	var folder string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if folder = strings.TrimSpace(scanner.Text()); folder != "" {
+			break
+		}
+	}
+	if folder == "" {
+		return ""
+	}
+	clean, ok := sanitizeFolder(folder, config.Options.OutputDir)
+	if !ok {
+		slog.Warn(fmt.Sprintf("Rejected classifier folder %q for %s", folder, name))
+		return ""
+	}
+	slog.Info(fmt.Sprintf("Classifier picked %s for %s", clean, name),
+		"event", "classified", "src", path, "target", clean)
+	return clean
+}
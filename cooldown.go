@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aiCooldown pauses the AI when most of its recent answers went to the
+// fallback folder, which usually means an outage or a bad setup rather
+// than unusual files. After the pause one file is let through as a probe;
+// the AI resumes if it gets a real folder and pauses again otherwise.
+type aiCooldown struct {
+	mu          sync.Mutex
+	rate        float64
+	pause       time.Duration
+	fallback    string
+	recent      []bool // ring of recent answers, true for fallback
+	next        int
+	filled      int
+	pausedUntil time.Time
+	probing     bool
+}
+
+// cooldown is nil when gpt.cooldown is empty.
+var cooldown *aiCooldown
+
+func newCooldown(cfg GptConfig, fallback string) *aiCooldown {
+	if cfg.cooldown <= 0 {
+		return nil
+	}
+	return &aiCooldown{
+		rate:     cfg.CooldownRate,
+		pause:    cfg.cooldown,
+		fallback: fallback,
+		recent:   make([]bool, cfg.CooldownWindow),
+	}
+}
+
+// allow reports whether a file may be sent to the AI now, and whether it
+// is the probe after a pause. Only the probe's own record or abandon call
+// ends the probe.
+func (c *aiCooldown) allow() (ok, probe bool) {
+	if c == nil {
+		return true, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pausedUntil.IsZero() {
+		return true, false
+	}
+	if c.probing || time.Now().Before(c.pausedUntil) {
+		return false, false
+	}
+	c.probing = true
+	slog.Info("Trying the AI again after the cooldown", "event", "ai_probe")
+	return true, true
+}
+
+// record adds the AI's answer for a file; "" means it went to fallback.
+// probe is what allow returned for the file.
+func (c *aiCooldown) record(target string, probe bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fellBack := target == "" || strings.EqualFold(target, c.fallback)
+	if probe {
+		c.probing = false
+		if fellBack {
+			c.pausedUntil = time.Now().Add(c.pause)
+			slog.Warn(fmt.Sprintf("AI still going to fallback, pausing it for another %v", c.pause), "event", "ai_paused")
+			return
+		}
+		c.pausedUntil = time.Time{}
+		slog.Info("AI is answering again, resuming it", "event", "ai_resumed")
+		return
+	}
+	if !c.pausedUntil.IsZero() {
+		// answers to files sent before the pause started
+		return
+	}
+
+	c.recent[c.next] = fellBack
+	c.next = (c.next + 1) % len(c.recent)
+	c.filled = min(c.filled+1, len(c.recent))
+	if c.filled < len(c.recent) {
+		return
+	}
+	var fallbacks int
+	for _, f := range c.recent {
+		if f {
+			fallbacks++
+		}
+	}
+	if float64(fallbacks) >= c.rate*float64(len(c.recent)) {
+		c.pausedUntil = time.Now().Add(c.pause)
+		c.filled, c.next = 0, 0
+		slog.Warn(fmt.Sprintf("AI sent %d of the last %d files to fallback, pausing it for %v; files go by rules and fallback meanwhile",
+			fallbacks, len(c.recent), c.pause), "event", "ai_paused")
+	}
+}
+
+// abandon ends a probe let through by allow that got no answer, e.g. as the
+// queue was full or the file timed out, so another file can probe instead.
+// probe is what allow returned for the file; other files leave it alone.
+func (c *aiCooldown) abandon(probe bool) {
+	if c == nil || !probe {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
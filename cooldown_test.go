@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// pausedCooldown returns a cooldown whose pause just ended.
+func pausedCooldown(t *testing.T) *aiCooldown {
+	t.Helper()
+	c := newCooldown(GptConfig{cooldown: time.Hour, CooldownRate: 1, CooldownWindow: 2}, "Unsorted")
+	c.record("", false)
+	c.record("Unsorted", false)
+	if ok, _ := c.allow(); ok {
+		t.Fatal("allow() = true right after the pause started")
+	}
+	c.pausedUntil = time.Now().Add(-time.Second)
+	return c
+}
+
+func TestCooldownAbandonedProbe(t *testing.T) {
+	c := pausedCooldown(t)
+	ok, probe := c.allow()
+	if !ok || !probe {
+		t.Fatalf("allow() = %v, %v after the pause, want a probe", ok, probe)
+	}
+	if ok, _ := c.allow(); ok {
+		t.Fatal("allow() = true while a probe is out")
+	}
+	c.abandon(probe)
+	ok, probe = c.allow()
+	if !ok || !probe {
+		t.Fatalf("allow() = %v, %v after the probe was abandoned, want a new probe", ok, probe)
+	}
+	c.record("Work", probe)
+	if ok, probe := c.allow(); !ok || probe {
+		t.Errorf("allow() = %v, %v after the probe got a real folder, want the AI resumed", ok, probe)
+	}
+}
+
+func TestCooldownOtherFilesKeepTheProbe(t *testing.T) {
+	c := pausedCooldown(t)
+	if _, probe := c.allow(); !probe {
+		t.Fatal("allow() gave no probe after the pause")
+	}
+	// files sent before the pause time out or answer while the probe is out
+	c.abandon(false)
+	c.record("Work", false)
+	if ok, _ := c.allow(); ok {
+		t.Error("allow() = true while the probe is still out")
+	}
+	if c.pausedUntil.IsZero() {
+		t.Error("AI resumed by an answer that wasn't the probe")
+	}
+}
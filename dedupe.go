@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// hashIndexName is the default hash_index file, kept in output_dir.
+const hashIndexName = ".entropy-hashes"
+
+// hashIndex is the set of SHA-256 hashes of files already organized,
+// persisted as one hex hash per line so it survives restarts.
+type hashIndex struct {
+	mu      sync.Mutex
+	path    string
+	seen    map[string]bool
+	pending map[string]bool // claimed by moves still in progress
+}
+
+func loadHashIndex(path string) *hashIndex {
+	idx := &hashIndex{path: path, seen: make(map[string]bool), pending: make(map[string]bool)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("Could not read hash index %s: %v", path, err))
+		}
+		return idx
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			idx.seen[line] = true
+		}
+	}
+	return idx
+}
+
+// claim reserves hash for a file about to be moved. It returns false if
+// the hash is already known or claimed, i.e. the file is a duplicate.
+func (idx *hashIndex) claim(hash string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.seen[hash] || idx.pending[hash] {
+		return false
+	}
+	idx.pending[hash] = true
+	return true
+}
+
+// release drops a claim whose move didn't happen. It's safe on a nil index.
+func (idx *hashIndex) release(hash string) {
+	if idx == nil || hash == "" {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.pending, hash)
+}
+
+// add records hash as organized, persisting it.
+func (idx *hashIndex) add(hash string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.pending, hash)
+	if idx.seen[hash] {
+		return
+	}
+	idx.seen[hash] = true
+
+	f, err := os.OpenFile(idx.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not update hash index %s: %v", idx.path, err))
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, hash)
+}
+
+// hashFile returns the hex SHA-256 of the file, streaming its contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
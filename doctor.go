@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// doctorTimeout bounds the test request to the AI.
+const doctorTimeout = 30 * time.Second
+
+// doctorReport prints the checklist of "entropy doctor" as it goes.
+type doctorReport struct {
+	failed bool
+}
+
+func (r *doctorReport) pass(format string, args ...any) {
+	fmt.Printf("[ok]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(format string, args ...any) {
+	fmt.Printf("[warn] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(format string, args ...any) {
+	r.failed = true
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+}
+
+// runDoctor implements "entropy doctor": it checks the config, the folders,
+// the classifier and the AI before a long run, and exits with 1 if
+// anything would stop files from being sorted properly.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	fs.Parse(args)
+
+	var r doctorReport
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		r.fail("config %s: %v", *configPath, err)
+		os.Exit(1)
+	}
+	r.pass("config %s parses (%d rule(s))", *configPath, len(config.Rules))
+
+	for _, dir := range config.Options.WatchDirs {
+		checkWritable(&r, "watch dir", dir)
+	}
+	if !slices.Contains(config.Options.WatchDirs, config.Options.OutputDir) {
+		checkWritable(&r, "output dir", config.Options.OutputDir)
+	}
+
+	if config.Options.KnowledgeBase != "" {
+		if knowledge, err := loadKnowledgeBase(config.Options.KnowledgeBase, config.Gpt.maxKnowledge); err != nil {
+			r.fail("%v", err)
+		} else {
+			r.pass("knowledge base %s (%s)", config.Options.KnowledgeBase, formatSize(int64(len(knowledge))))
+		}
+	}
+
+	if len(config.Classifier.Command) > 0 {
+		if path, err := exec.LookPath(config.Classifier.Command[0]); err != nil {
+			r.fail("classifier command %s: %v", config.Classifier.Command[0], err)
+		} else {
+			r.pass("classifier command %s", path)
+		}
+	}
+
+	if !config.Gpt.Enabled {
+		r.pass("AI is disabled, unmatched files go to %s", config.Options.FallbackFolder)
+	} else {
+		checkLimits(&r, config.Gpt)
+		checkAI(&r, config)
+	}
+
+	if r.failed {
+		os.Exit(1)
+	}
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(r *doctorReport, what, dir string) {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		r.warn("%s %s doesn't exist yet, it's created on start", what, dir)
+		return
+	}
+	if err != nil {
+		r.fail("%s %s: %v", what, dir, err)
+		return
+	}
+	if !info.IsDir() {
+		r.fail("%s %s isn't a folder", what, dir)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".entropy-doctor-*")
+	if err != nil {
+		r.fail("%s %s isn't writable: %v", what, dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.pass("%s %s is writable", what, dir)
+}
+
+// checkLimits looks for rate limit settings that can't work well.
+func checkLimits(r *doctorReport, cfg GptConfig) {
+	perMinute := cfg.RequestsPerMinute
+	if perMinute <= 0 {
+		perMinute = 20
+	}
+	burst := max(cfg.Burst, 1)
+	workers := max(cfg.Workers, 1)
+	switch {
+	case perMinute > 1000:
+		r.warn("gpt.requests_per_minute %v is higher than most API quotas allow", perMinute)
+	case workers > burst:
+		r.warn("gpt.workers %d is more than gpt.burst %d, the extra workers only wait on the rate limiter", workers, burst)
+	default:
+		r.pass("rate limit %v request(s) per minute, burst %d, %d worker(s)", perMinute, burst, workers)
+	}
+}
+
+// checkAI sends one small request to the configured model.
+func checkAI(r *doctorReport, config Config) {
+	if config.Gpt.Provider != "openai" && config.Gpt.Model == "" {
+		r.fail("gpt.model is not set, e.g. gemini-2.0-flash-lite")
+		return
+	}
+	suggester, err := newSuggester(config.Gpt, "", false)
+	if err != nil {
+		r.fail("AI client: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	text, err := suggester.Suggest(ctx, "notes.txt", "text/plain, 1 KB", "Documents\n")
+	took := time.Since(start).Round(time.Millisecond)
+	switch {
+	case err != nil && isFatalAIError(err):
+		r.fail("AI request was rejected, check gpt.api_key and gpt.model: %v", err)
+	case err != nil:
+		r.fail("AI request failed: %v", err)
+	default:
+		if _, err := parseSuggestion(text); err != nil {
+			r.warn("AI answered in %v, but not in the expected format: %v", took, err)
+			return
+		}
+		r.pass("AI answered in %v", took)
+	}
+}
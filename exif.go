@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// heicExifScanLimit bounds how much of a HEIC file is searched for its
+// EXIF block.
+const heicExifScanLimit = 4 << 20
+
+// photoTakenAt returns the EXIF DateTimeOriginal of a JPEG or HEIC photo.
+func photoTakenAt(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".heic", ".heif":
+		// HEIC keeps a raw "Exif\0\0" block somewhere in its item data
+		data, err := io.ReadAll(io.LimitReader(f, heicExifScanLimit))
+		if err != nil {
+			return time.Time{}, false
+		}
+		i := bytes.Index(data, []byte("Exif\x00\x00"))
+		if i < 0 {
+			return time.Time{}, false
+		}
+		r = bytes.NewReader(data[i:])
+	}
+
+	x, err := exif.Decode(r)
+	if err != nil {
+		return time.Time{}, false
+	}
+	tag, err := x.Get(exif.DateTimeOriginal)
+	if err != nil {
+		return time.Time{}, false
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return time.Time{}, false
+	}
+	taken, err := time.ParseInLocation("2006:01:02 15:04:05", strings.TrimRight(value, "\x00"), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return taken, true
+}
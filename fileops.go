@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveFile renames src to dst, falling back to copy+delete when they live on
+// different filesystems. src may be a file or a folder.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err := copyDir(src, dst, 0); err != nil {
+			return err
+		}
+		return os.RemoveAll(src)
+	}
+
+	if err := copyFile(src, dst, 0); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// alreadyInPlace reports whether transferring src to dst with action would
+// change nothing: dst is src itself, a link to it, or for copies a file
+// with the same contents. It keeps files that are already sorted from
+// getting a numbered second copy.
+func alreadyInPlace(src, dst, action string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return true
+	}
+	if action != "copy" && action != "review" || !srcInfo.Mode().IsRegular() || !dstInfo.Mode().IsRegular() ||
+		srcInfo.Size() != dstInfo.Size() {
+		return false
+	}
+	srcHash, err1 := hashFile(src)
+	dstHash, err2 := hashFile(dst)
+	return err1 == nil && err2 == nil && srcHash == dstHash
+}
+
+// symlinkPath creates dst as a symbolic link to src, which stays in place.
+func symlinkPath(src, dst string) error {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, dst)
+}
+
+// copyPath copies a file or a folder tree from src to dst, leaving src
+// untouched. Copied files get mode, or keep theirs if it's 0.
+func copyPath(src, dst string, mode os.FileMode) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return copyDir(src, dst, mode)
+	}
+	return copyFile(src, dst, mode)
+}
+
+// copyDir copies the folder tree at src to dst, keeping folder times too.
+// A partially copied dst is removed on failure.
+func copyDir(src, dst string, mode os.FileMode) error {
+	type copiedDir struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []copiedDir
+	err := filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, copiedDir{target, info})
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(path, target, mode)
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+
+	// copying into a folder bumps its mtime, so times are set once
+	// everything is in place, innermost folders first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := os.Chtimes(dir.path, accessTime(dir.info), dir.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, keeping the access and modification times
+// and the file mode unless mode is set. A partially written dst is removed
+// on failure.
+func copyFile(src, dst string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if mode == 0 {
+		mode = info.Mode().Perm()
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, accessTime(info), info.ModTime())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hourRange is a span of gpt.active_hours, from the start of hour start to
+// the start of hour end. Ranges with end before start go past midnight.
+type hourRange struct {
+	start, end int
+}
+
+// parseActiveHours parses gpt.active_hours, e.g. "0-6" or "22-24,0-6".
+func parseActiveHours(s string) ([]hourRange, error) {
+	var ranges []hourRange
+	for _, part := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("%q must look like 0-6", part)
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(from))
+		end, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+			return nil, fmt.Errorf("%q must be two different hours from 0 to 24", part)
+		}
+		ranges = append(ranges, hourRange{start, end})
+	}
+	return ranges, nil
+}
+
+// inActiveHours reports whether t falls in one of ranges.
+func inActiveHours(t time.Time, ranges []hourRange) bool {
+	h := t.Hour()
+	for _, r := range ranges {
+		if r.start < r.end && h >= r.start && h < r.end {
+			return true
+		}
+		if r.start > r.end && (h >= r.start || h < r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextActiveTime returns when the next range after t starts.
+func nextActiveTime(t time.Time, ranges []hourRange) time.Time {
+	// not t.Truncate, which rounds in UTC and misses half-hour zones
+	hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	for i := 1; i <= 24; i++ {
+		next := hour.Add(time.Duration(i) * time.Hour)
+		if inActiveHours(next, ranges) {
+			return next
+		}
+	}
+	return t
+}
+
+// waitForActiveHours reports whether path may go to the AI now. Outside
+// gpt.active_hours it's false, unless outside_hours is "wait": then it
+// holds the file until the window opens, or false if ctx is cancelled.
+func waitForActiveHours(ctx context.Context, path string, cfg GptConfig) bool {
+	if len(cfg.activeHours) == 0 {
+		return true
+	}
+	now := time.Now().In(cfg.location)
+	if inActiveHours(now, cfg.activeHours) {
+		return true
+	}
+	next := nextActiveTime(now, cfg.activeHours)
+	if cfg.OutsideHours != "wait" {
+		slog.Debug(fmt.Sprintf("Outside gpt.active_hours until %s, not asking the AI about %s", next.Format("15:04 MST"), filepath.Base(path)))
+		return false
+	}
+	slog.Info(fmt.Sprintf("Holding %s for the AI until %s", filepath.Base(path), next.Format("15:04 MST")),
+		"event", "ai_waiting", "src", path)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
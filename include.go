@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile reads path into config, after the files it includes.
+// Files are merged in order: later options and gpt settings override
+// earlier ones, rules and ignore lists are appended to, and extension_map
+// and route_templates are merged key by key. Every file read is added to
+// files so reloads can watch them all.
+func readConfigFile(path string, config *Config, files *[]string) error {
+	path = filepath.Clean(path)
+	for _, file := range *files {
+		if file == path {
+			return fmt.Errorf("%s is included more than once", path)
+		}
+	}
+	*files = append(*files, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && len(*files) == 1 {
+			return fmt.Errorf("couldn't open file %s: %w (run \"entropy init\" to create one)", path, err)
+		}
+		return fmt.Errorf("couldn't open file %s: %w", path, err)
+	}
+
+	// includes come first so this file overrides them
+	var layer Config
+	if err := yaml.Unmarshal(data, &layer); err != nil {
+		return fmt.Errorf("invalid YAML in %s: %w", path, err)
+	}
+	for _, include := range layer.Include {
+		include = os.ExpandEnv(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := readConfigFile(include, config, files); err != nil {
+			return err
+		}
+	}
+
+	// decoding on top of config only replaces the fields set in this file;
+	// lists are replaced too, so they are appended afterwards
+	prev := *config
+	prev.Ignore = cloneIgnoreLists(config.Ignore)
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("invalid YAML in %s: %w", path, err)
+	}
+	config.Rules = append(prev.Rules, layer.Rules...)
+	config.Ignore.Files = append(prev.Ignore.Files, layer.Ignore.Files...)
+	config.Ignore.Extensions = append(prev.Ignore.Extensions, layer.Ignore.Extensions...)
+	config.Ignore.Folders = append(prev.Ignore.Folders, layer.Ignore.Folders...)
+	config.Ignore.Patterns = append(prev.Ignore.Patterns, layer.Ignore.Patterns...)
+	config.Ignore.IgnoreFiles = append(prev.Ignore.IgnoreFiles, layer.Ignore.IgnoreFiles...)
+	config.Ignore.InProgressSuffixes = append(prev.Ignore.InProgressSuffixes, layer.Ignore.InProgressSuffixes...)
+	config.Include = nil
+	return nil
+}
+
+// cloneIgnoreLists copies the ignore lists so appending to them can't write
+// into the backing arrays of an earlier file's lists.
+func cloneIgnoreLists(ignore IgnoreConfig) IgnoreConfig {
+	clone := func(list []string) []string { return append([]string(nil), list...) }
+	ignore.Files = clone(ignore.Files)
+	ignore.Extensions = clone(ignore.Extensions)
+	ignore.Folders = clone(ignore.Folders)
+	ignore.Patterns = clone(ignore.Patterns)
+	ignore.IgnoreFiles = clone(ignore.IgnoreFiles)
+	ignore.InProgressSuffixes = clone(ignore.InProgressSuffixes)
+	return ignore
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base.yaml"), `
+options:
+  mode: copy
+  recursive: true
+gpt:
+  model: base-model
+  workers: 2
+ignore:
+  extensions: [".tmp"]
+rules:
+  - pattern: "base"
+    target: "Base"
+`)
+	writeFile(t, filepath.Join(dir, "shared", "more.yaml"), `
+gpt:
+  model: shared-model
+ignore:
+  extensions: [".bak"]
+  folders: ["node_modules"]
+rules:
+  - pattern: "shared"
+    target: "Shared"
+`)
+	root := filepath.Join(dir, "rules.yaml")
+	writeFile(t, root, `
+include: ["base.yaml", "shared/more.yaml"]
+options:
+  mode: move
+rules:
+  - pattern: "main"
+    target: "Main"
+`)
+
+	var config Config
+	var files []string
+	if err := readConfigFile(root, &config, &files); err != nil {
+		t.Fatal(err)
+	}
+
+	wantFiles := []string{root, filepath.Join(dir, "base.yaml"), filepath.Join(dir, "shared", "more.yaml")}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("files = %q, want %q", files, wantFiles)
+	}
+	var targets []string
+	for _, rule := range config.Rules {
+		targets = append(targets, rule.Target)
+	}
+	if want := []string{"Base", "Shared", "Main"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("rule targets = %q, want %q in include order", targets, want)
+	}
+	if want := []string{".tmp", ".bak"}; !reflect.DeepEqual(config.Ignore.Extensions, want) {
+		t.Errorf("ignore.extensions = %q, want %q", config.Ignore.Extensions, want)
+	}
+	if want := []string{"node_modules"}; !reflect.DeepEqual(config.Ignore.Folders, want) {
+		t.Errorf("ignore.folders = %q, want %q", config.Ignore.Folders, want)
+	}
+	if config.Options.Mode != "move" || !config.Options.Recursive {
+		t.Errorf("options mode %q, recursive %v; want move from rules.yaml and recursive from base.yaml",
+			config.Options.Mode, config.Options.Recursive)
+	}
+	if config.Gpt.Model != "shared-model" || config.Gpt.Workers != 2 {
+		t.Errorf("gpt model %q, workers %d; want shared-model from more.yaml and 2 from base.yaml",
+			config.Gpt.Model, config.Gpt.Workers)
+	}
+}
+
+func TestReadConfigFileIncludedTwice(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"cycle", map[string]string{
+			"rules.yaml": `include: ["a.yaml"]`,
+			"a.yaml":     `include: ["rules.yaml"]`,
+		}},
+		{"same file twice", map[string]string{
+			"rules.yaml": `include: ["a.yaml", "b.yaml"]`,
+			"a.yaml":     `include: ["b.yaml"]`,
+			"b.yaml":     `rules: []`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+			var config Config
+			var files []string
+			err := readConfigFile(filepath.Join(dir, "rules.yaml"), &config, &files)
+			if err == nil || !strings.Contains(err.Error(), "is included more than once") {
+				t.Errorf("readConfigFile error = %v, want \"included more than once\"", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// indexRecord is one organized file in the search index.
+type indexRecord struct {
+	Src       string
+	Dest      string
+	Size      int64
+	Hash      string
+	DecidedBy string
+	Time      time.Time
+}
+
+// fileIdx is the search index, nil unless options.index_db is set.
+var fileIdx *fileIndex
+
+// openIndex opens the search index at path, logging why when it can't.
+func openIndex(path string) {
+	idx, err := openFileIndex(path)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Not recording organized files in %s: %v", path, err))
+		return
+	}
+	fileIdx = idx
+}
+
+// recordIndex adds a finished move of task.src to dest to the search index.
+func recordIndex(task moveTask, dest string) {
+	if fileIdx == nil || task.opts.DryRun {
+		return
+	}
+	rec := indexRecord{Src: task.src, Dest: dest, Hash: task.hash, DecidedBy: task.decidedBy, Time: time.Now()}
+	if info, err := os.Stat(dest); err == nil && !info.IsDir() {
+		rec.Size = info.Size()
+		if rec.Hash == "" {
+			rec.Hash, _ = hashFile(dest)
+		}
+	}
+	if err := fileIdx.record(rec); err != nil {
+		slog.Warn(fmt.Sprintf("Could not update index for %s: %v", dest, err))
+	}
+}
+
+// runSearch implements "entropy search <query>": it lists organized files
+// whose original or new path contains query.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	limit := fs.Int("limit", 50, "show at most this many results, newest first")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: entropy search [--config rules.yaml] [--limit N] <query>")
+		os.Exit(2)
+	}
+
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.Options.IndexDB == "" {
+		log.Fatal("options.index_db is not set, so there is no index to search")
+	}
+	idx, err := openFileIndex(config.Options.IndexDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer idx.close()
+
+	records, err := idx.search(fs.Arg(0), *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, rec := range records {
+		fmt.Printf("%s  %s → %s  (%s, %s)\n", rec.Time.Local().Format("2006-01-02 15:04"), rec.Src, rec.Dest, rec.DecidedBy, formatSize(rec.Size))
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !sqlite
+
+package main
+
+import "errors"
+
+// fileIndex needs SQLite, which is only compiled in with -tags sqlite so
+// regular builds don't carry the dependency.
+type fileIndex struct{}
+
+var errNoSQLite = errors.New("entropy was built without SQLite support, rebuild with: go build -tags sqlite")
+
+func openFileIndex(string) (*fileIndex, error) {
+	return nil, errNoSQLite
+}
+
+func (*fileIndex) record(indexRecord) error { return errNoSQLite }
+
+func (*fileIndex) search(string, int) ([]indexRecord, error) { return nil, errNoSQLite }
+
+func (*fileIndex) close() error { return nil }
@@ -0,0 +1,73 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// fileIndex records organized files in a SQLite database.
+type fileIndex struct {
+	db *sql.DB
+}
+
+func openFileIndex(path string) (*fileIndex, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// one connection keeps writes from the move workers in order
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS files (
+		src        TEXT NOT NULL,
+		dest       TEXT NOT NULL,
+		size       INTEGER NOT NULL,
+		hash       TEXT NOT NULL,
+		decided_by TEXT NOT NULL,
+		time       INTEGER NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &fileIndex{db: db}, nil
+}
+
+func (idx *fileIndex) record(rec indexRecord) error {
+	_, err := idx.db.Exec(`INSERT INTO files (src, dest, size, hash, decided_by, time) VALUES (?, ?, ?, ?, ?, ?)`,
+		rec.Src, rec.Dest, rec.Size, rec.Hash, rec.DecidedBy, rec.Time.UnixMilli())
+	return err
+}
+
+// search returns up to limit records whose src or dest contains query,
+// ignoring case, newest first.
+func (idx *fileIndex) search(query string, limit int) ([]indexRecord, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := idx.db.Query(`SELECT src, dest, size, hash, decided_by, time FROM files
+		WHERE src LIKE ?1 ESCAPE '\' OR dest LIKE ?1 ESCAPE '\'
+		ORDER BY time DESC LIMIT ?2`, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []indexRecord
+	for rows.Next() {
+		var rec indexRecord
+		var millis int64
+		if err := rows.Scan(&rec.Src, &rec.Dest, &rec.Size, &rec.Hash, &rec.DecidedBy, &millis); err != nil {
+			return nil, err
+		}
+		rec.Time = time.UnixMilli(millis)
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (idx *fileIndex) close() error {
+	return idx.db.Close()
+}
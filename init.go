@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// starterConfig is written by "entropy init".
+const starterConfig = `# entropy config. See the README for every option.
+
+options:
+  watch_dir: "entropy" # Folder to watch for new files.
+  preserve_structure: false # Only sort into folders that already exist.
+  process_existing: false # Also sort files already in the folder on startup.
+  dry_run: false # Log what would happen without moving anything.
+
+# Rules are regexes matched against the filename, checked in order.
+# The first match wins.
+rules:
+  - pattern: "\\.(pdf|docx?|odt|txt|md)$"
+    case_insensitive: true
+    target: "Documents"
+
+  - pattern: "\\.(jpe?g|png|gif|heic|webp)$"
+    case_insensitive: true
+    target: "Images/{year}"
+
+  - pattern: "\\.(mp4|mov|mkv|avi)$"
+    case_insensitive: true
+    target: "Videos"
+
+  - pattern: "\\.(mp3|wav|flac|m4a)$"
+    case_insensitive: true
+    target: "Music"
+
+  - pattern: "\\.(zip|tar|gz|rar|7z)$"
+    case_insensitive: true
+    target: "Archives"
+
+ignore:
+  os_defaults: true # Skip .DS_Store, Thumbs.db, desktop.ini and friends.
+
+# Files no rule matches go to options.fallback_folder (Unsorted) unless the
+# AI is enabled.
+gpt:
+  enabled: false
+  api_key: "" # Your Gemini API key.
+  instructions: |
+    You are a file organization assistant. Given filename and MIME type,
+    suggest a folder path.
+`
+
+// runInit implements "entropy init", writing a starter config unless one
+// already exists.
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	configPath := flags.String("config", "rules.yaml", "path of the config file to create")
+	flags.Parse(args)
+
+	f, err := os.OpenFile(*configPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		log.Fatalf("%s already exists, leaving it alone", *configPath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := f.WriteString(starterConfig); err != nil {
+		f.Close()
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Wrote", *configPath)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// promptMu keeps prompts for files settling at the same time apart
+	promptMu sync.Mutex
+	// stdinLines is fed by a single reader goroutine, so a prompt can give
+	// up on shutdown without leaving a read behind
+	stdinLines     chan string
+	stdinLinesOnce sync.Once
+)
+
+// readLine returns the next line typed on stdin, or false once stdin is
+// closed or ctx is cancelled.
+func readLine(ctx context.Context) (string, bool) {
+	stdinLinesOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				stdinLines <- scanner.Text()
+			}
+			close(stdinLines)
+		}()
+	})
+	select {
+	case line, ok := <-stdinLines:
+		return strings.TrimSpace(line), ok
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// confirmTarget asks whether src should go to target, for --interactive.
+// It returns the folder to use and how it was decided: decidedBy if it was
+// accepted, "fallback" if it was rejected or "manual" if another folder was
+// typed. ok is false when there's no answer, and the file stays in place.
+func confirmTarget(ctx context.Context, src, target, decidedBy, action string, opts Options) (string, string, bool) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	if action == "" {
+		action = opts.Mode
+	}
+	verb := map[string]string{"copy": "Copy", "review": "Copy", "symlink": "Link", "delete": "Delete"}[action]
+	if verb == "" {
+		verb = "Move"
+	}
+	question := fmt.Sprintf("%s %s → %s?", verb, filepath.Base(src), target)
+	if action == "delete" {
+		question = fmt.Sprintf("Delete %s?", filepath.Base(src))
+	}
+
+	for {
+		fmt.Fprintf(os.Stderr, "%s [y/n/edit] ", question)
+		answer, ok := readLine(ctx)
+		if !ok {
+			fmt.Fprintln(os.Stderr)
+			return "", "", false
+		}
+		switch strings.ToLower(answer) {
+		case "", "y", "yes":
+			return target, decidedBy, true
+		case "n", "no":
+			return opts.FallbackFolder, "fallback", true
+		case "e", "edit":
+			folder, ok := askFolder(ctx, opts.OutputDir)
+			if !ok {
+				return "", "", false
+			}
+			if folder != "" {
+				return folder, "manual", true
+			}
+		}
+	}
+}
+
+// askFolder reads a folder for confirmTarget until one inside outputDir is
+// given. An empty line returns "" to go back to the question.
+func askFolder(ctx context.Context, outputDir string) (string, bool) {
+	for {
+		fmt.Fprint(os.Stderr, "Folder (empty to go back): ")
+		folder, ok := readLine(ctx)
+		if !ok {
+			fmt.Fprintln(os.Stderr)
+			return "", false
+		}
+		if folder == "" {
+			return "", true
+		}
+		if clean, valid := sanitizeFolder(folder, outputDir); valid {
+			return clean, true
+		}
+		fmt.Fprintf(os.Stderr, "%q isn't a folder inside %s\n", folder, outputDir)
+	}
+}
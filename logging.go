@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// textHandler keeps the original "2006/01/02 15:04:05 message" log lines.
+// Attributes are only emitted by the JSON format, so messages must stay
+// readable on their own.
+type textHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.out, "%s %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Message)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *textHandler) WithGroup(string) slog.Handler      { return h }
+
+// errorRecorder remembers the last error logged, for /status.
+type errorRecorder struct {
+	slog.Handler
+}
+
+func (h errorRecorder) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		health.setLastError(r.Time, r.Message)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h errorRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return errorRecorder{h.Handler.WithAttrs(attrs)}
+}
+
+func (h errorRecorder) WithGroup(name string) slog.Handler {
+	return errorRecorder{h.Handler.WithGroup(name)}
+}
+
+// logLevel is shared by both formats so it can change on config reload.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the slog handler for format ("text" or "json").
+// Plain log.Printf calls are routed through it at info level.
+func setupLogging(format string, level slog.Level) {
+	logLevel.Set(level)
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
+	} else {
+		handler = &textHandler{mu: &sync.Mutex{}, out: os.Stderr, level: logLevel}
+	}
+	slog.SetDefault(slog.New(errorRecorder{handler}))
+}
@@ -3,66 +3,388 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fsnotify/fsnotify"
 	pdf "github.com/ledongthuc/pdf"
 	"github.com/rwcarlsen/goexif/exif"
 	"golang.org/x/time/rate"
-	"google.golang.org/genai"
-	"gopkg.in/yaml.v3"
 )
 
 type Options struct {
-	PreserveStructure bool   `yaml:"preserve_structure"`
-	KnowledgeBase     string `yaml:"knowledge_base"`
+	PreserveStructure bool     `yaml:"preserve_structure"`
+	KnowledgeBase     string   `yaml:"knowledge_base"`
+	WatchDir          string   `yaml:"watch_dir"`
+	WatchDirs         []string `yaml:"watch_dirs"`
+	OutputDir         string   `yaml:"output_dir"`
+	Recursive         bool     `yaml:"recursive"`
+	ProcessExisting   bool     `yaml:"process_existing"`
+	MoveFolders       bool     `yaml:"move_folders"`
+	DryRun            bool     `yaml:"dry_run"`
+	RulesOnly         bool     `yaml:"rules_only"` // skip the classifier and AI, for testing rules
+	OnConflict        string   `yaml:"on_conflict"`
+	UnresolvedTokens  string   `yaml:"unresolved_tokens"`
+	DetectDuplicates  bool     `yaml:"detect_duplicates"`
+	OnDuplicate       string   `yaml:"on_duplicate"` // "skip" (default) or "move"
+	DuplicatesFolder  string   `yaml:"duplicates_folder"`
+	TrashFolder       string   `yaml:"trash_folder"`
+	FallbackFolder    string   `yaml:"fallback_folder"`
+	// e.g. {"ai": "ai/{target}"}; keys are rule, extension, marker, ai,
+	// fallback or duplicate
+	RouteTemplates  map[string]string `yaml:"route_templates"`
+	SafeDelete      bool              `yaml:"safe_delete"`
+	HashIndex       string            `yaml:"hash_index"`
+	IndexDB         string            `yaml:"index_db"`
+	Mode            string            `yaml:"mode"` // "move" (default), "copy" or "review"
+	SettleInterval  string            `yaml:"settle_interval"`
+	SettleTimeout   string            `yaml:"settle_timeout"`
+	OnStalled       string            `yaml:"on_stalled"` // skip, process or move
+	StalledFolder   string            `yaml:"stalled_folder"`
+	FileTimeout     string            `yaml:"file_timeout"`
+	OnEmpty         string            `yaml:"on_empty"` // sort, skip or move
+	EmptyFolder     string            `yaml:"empty_folder"`
+	SummaryInterval string            `yaml:"summary_interval"`
+	LogFormat       string            `yaml:"log_format"` // "text" (default) or "json"
+	LogLevel        string            `yaml:"log_level"`  // "debug", "info" (default), "warn" or "error"
+	Events          []string          `yaml:"events"`     // "create" (default), "rename", "write"
+	HTTPAddress     string            `yaml:"http_address"`
+	PollInterval    string            `yaml:"poll_interval"`
+	MaxDepth        int               `yaml:"max_depth"`
+	SlugifyFolders  bool              `yaml:"slugify_folders"` // "My Docs" becomes "my-docs"
+	DirMode         string            `yaml:"dir_mode"`        // octal, e.g. "0750"; defaults to "0755"
+	FileMode        string            `yaml:"file_mode"`       // octal mode for copies; empty keeps the original's
+	MoveWorkers     int               `yaml:"move_workers"`
+	Debounce        string            `yaml:"debounce"`
+	StatusEndpoints bool              `yaml:"status_endpoints"`
+
+	events          fsnotify.Op
+	logLevel        slog.Level
+	settleInterval  time.Duration
+	settleTimeout   time.Duration
+	fileTimeout     time.Duration
+	summaryInterval time.Duration
+	pollInterval    time.Duration
+	debounce        time.Duration
+	dirMode         os.FileMode
+	fileMode        os.FileMode
+	interactive     bool // set by --interactive
 }
 
+const defaultDir = "entropy"
+
 type Rule struct {
-	Pattern string `yaml:"pattern"`
-	Target  string `yaml:"target"`
+	Pattern string   `yaml:"pattern"`
+	Target  string   `yaml:"target"`
+	Type    string   `yaml:"type"`   // "regex" (default) or "glob"
+	Mime    string   `yaml:"mime"`   // e.g. "image/*", matched against the detected content type
+	Action  string   `yaml:"action"` // "move", "copy", "symlink" or "delete"; defaults to options.mode
+	Then    []string `yaml:"then"`   // post-actions run after the move: "meta", "readonly"
+	Rename  string   `yaml:"rename"` // new name, e.g. "{date}_{name}.{ext}", see renameBase
+	Hint    string   `yaml:"hint"`   // extra context for the AI about matching files
+
+	// OlderThan only matches files last modified longer ago, e.g. "30d" or
+	// "12h". It adds to the pattern and mime, all must hold.
+	OlderThan string `yaml:"older_than"`
+	// MinSize and MaxSize only match files of at least or at most that
+	// size, e.g. "500MB". Like OlderThan they add to the other conditions.
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+
+	// Priority orders rules before matching, highest first; rules with
+	// the same priority keep their order in the file.
+	Priority int `yaml:"priority"`
+	// Continue keeps checking later rules after this one matched. Their
+	// post-actions add up and the last target and action win.
+	Continue bool `yaml:"continue"`
+
+	CaseInsensitive bool `yaml:"case_insensitive"`
+
+	re        *regexp.Regexp
+	olderThan time.Duration
+	minBytes  int64
+	maxBytes  int64 // 0 means no limit
+	number    int   // position in the file, for logs
+}
+
+// compileRules validates every rule pattern and precompiles the regexes so
+// matching never has to, then sorts rules by priority.
+func compileRules(rules []Rule) error {
+	var errs []error
+	seen := make(map[string]int)
+	for i := range rules {
+		rule := &rules[i]
+		rule.number = i + 1
+		if rule.Mime != "" {
+			if _, err := filepath.Match(rule.Mime, ""); err != nil {
+				errs = append(errs, fmt.Errorf("invalid mime in rule %d %q: %w", i+1, rule.Mime, err))
+				continue
+			}
+		}
+		switch rule.Action {
+		case "", "move", "copy", "symlink", "delete":
+		default:
+			errs = append(errs, fmt.Errorf("invalid action %q in rule %d: must be move, copy, symlink or delete", rule.Action, i+1))
+		}
+		if strings.ContainsAny(rule.Rename, `/\`) {
+			errs = append(errs, fmt.Errorf("rename in rule %d %q can't contain a folder, use target for that", i+1, rule.Rename))
+		}
+		for _, name := range rule.Then {
+			if postActions[name] == nil {
+				errs = append(errs, fmt.Errorf("unknown post-action %q in rule %d: must be meta or readonly", name, i+1))
+			}
+		}
+		if rule.OlderThan != "" {
+			age, err := parseAge(rule.OlderThan)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid older_than in rule %d: %w", i+1, err))
+				continue
+			}
+			rule.olderThan = age
+		}
+		if rule.MinSize != "" {
+			size, err := parseSize(rule.MinSize)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid min_size in rule %d: %w", i+1, err))
+				continue
+			}
+			rule.minBytes = size
+		}
+		if rule.MaxSize != "" {
+			size, err := parseSize(rule.MaxSize)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid max_size in rule %d: %w", i+1, err))
+				continue
+			}
+			rule.maxBytes = size
+		}
+		if rule.maxBytes > 0 && rule.minBytes > rule.maxBytes {
+			errs = append(errs, fmt.Errorf("rule %d has min_size %s above max_size %s and can never match", i+1, rule.MinSize, rule.MaxSize))
+			continue
+		}
+		if rule.Pattern == "" && rule.Mime == "" && !rule.checksInfo() {
+			errs = append(errs, fmt.Errorf("rule %d needs a pattern, a mime, older_than, min_size or max_size", i+1))
+			continue
+		}
+
+		switch {
+		case rule.Pattern == "":
+			// rule on mime, age or size only
+		case rule.Type == "" || rule.Type == "regex":
+			pattern := rule.Pattern
+			if rule.CaseInsensitive {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid regex in rule %d %q: %w", i+1, rule.Pattern, err))
+				continue
+			}
+			rule.re = re
+		case rule.Type == "glob":
+			if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("invalid glob in rule %d %q: %w", i+1, rule.Pattern, err))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("invalid type %q in rule %d: must be regex or glob", rule.Type, i+1))
+			continue
+		}
+
+		// a later rule with the same pattern can never match
+		key := fmt.Sprintf("%s|%t|%s|%s|%v|%d|%d", rule.Type, rule.CaseInsensitive, rule.Pattern, rule.Mime, rule.olderThan, rule.minBytes, rule.maxBytes)
+		if first, ok := seen[key]; ok && !rule.Continue && !rules[first-1].Continue {
+			shadowed, winner := i+1, first
+			if rule.Priority > rules[first-1].Priority {
+				shadowed, winner = first, i+1
+			}
+			errs = append(errs, fmt.Errorf("rule %d duplicates rule %d (%q) and will never match", shadowed, winner, rule.Pattern))
+		} else {
+			seen[key] = i + 1
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+	return nil
 }
 
 type GptConfig struct {
 	Enabled      bool   `yaml:"enabled"`
+	Provider     string `yaml:"provider"` // "gemini" (default) or "openai"
 	ApiKey       string `yaml:"api_key"`
+	ApiKeyFile   string `yaml:"api_key_file"`
 	Model        string `yaml:"model"`
 	Instructions string `yaml:"instructions"`
+	CacheTTL     string `yaml:"cache_ttl"`
+	CacheFile    string `yaml:"cache_file"`
+
+	RequestsPerMinute float64 `yaml:"requests_per_minute"`
+	Burst             int     `yaml:"burst"`
+	Workers           int     `yaml:"workers"`
+	MinConfidence     float64 `yaml:"min_confidence"`
+	BatchSize         int     `yaml:"batch_size"`
+	BatchWindow       string  `yaml:"batch_window"`
+	MaxFolderDepth    int     `yaml:"max_folder_depth"`
+	MaxFolders        int     `yaml:"max_folders"`
+	FuzzyDistance     int     `yaml:"fuzzy_distance"` // edits allowed to match an existing folder; -1 disables
+	MaxKnowledge      string  `yaml:"max_knowledge"`
+	OnFatalError      string  `yaml:"on_fatal_error"` // "disable" (default) or "continue"
+
+	// Cooldown pauses the AI for this long once CooldownRate of the last
+	// CooldownWindow answers went to the fallback folder.
+	Cooldown       string  `yaml:"cooldown"`
+	CooldownRate   float64 `yaml:"cooldown_rate"`
+	CooldownWindow int     `yaml:"cooldown_window"`
+
+	// ActiveHours limits AI calls to hours of the day in TimeZone, e.g.
+	// "0-6". Outside them files fall back, or wait with OutsideHours "wait".
+	ActiveHours  string `yaml:"active_hours"`
+	TimeZone     string `yaml:"timezone"`
+	OutsideHours string `yaml:"outside_hours"` // "fallback" (default) or "wait"
+
+	// QueueSize is how many files can wait for the AI workers; QueueFull
+	// says what happens to more: "block" (default) waits for room,
+	// "fallback" sends them to fallback_folder and "reject" leaves them.
+	QueueSize int    `yaml:"queue_size"`
+	QueueFull string `yaml:"queue_full"`
+
+	// PeekArchives lists what's inside zip files in the metadata, reading
+	// up to ArchiveMaxEntries entries of archives up to ArchiveMaxSize.
+	PeekArchives      bool   `yaml:"peek_archives"`
+	ArchiveMaxEntries int    `yaml:"archive_max_entries"`
+	ArchiveMaxSize    string `yaml:"archive_max_size"`
+
+	// AllowedFolders is a fixed menu of folders the AI must pick from.
+	AllowedFolders []string `yaml:"allowed_folders"`
+
+	cacheTTL       time.Duration
+	cooldown       time.Duration
+	activeHours    []hourRange
+	location       *time.Location
+	batchWindow    time.Duration
+	maxKnowledge   int64
+	archiveMaxSize int64
 }
 
 type Config struct {
-	Options Options      `yaml:"options"`
-	Ignore  IgnoreConfig `yaml:"ignore"`
-	Rules   []Rule       `yaml:"rules"`
-	Gpt     GptConfig    `yaml:"gpt"`
+	Include      []string            `yaml:"include"` // configs merged in before this one
+	Options      Options             `yaml:"options"`
+	Ignore       IgnoreConfig        `yaml:"ignore"`
+	ExtensionMap map[string][]string `yaml:"extension_map"` // target → extensions
+	Quarantine   QuarantineConfig    `yaml:"quarantine"`
+	Rules        []Rule              `yaml:"rules"`
+	Classifier   ClassifierConfig    `yaml:"classifier"`
+	Gpt          GptConfig           `yaml:"gpt"`
+
+	extensions map[string]string // lowercased ".ext" → target
+	files      []string          // every config file read, includes first
 }
 
 type Job struct {
 	filename string
+	hint     string // from the matching rules, see Rule.Hint
 	resultCh chan string
 }
 
 type IgnoreConfig struct {
-	OSDefaults bool     `yaml:"os_defaults"`
-	Files      []string `yaml:"files"`
-	Extensions []string `yaml:"extensions"`
-	Folders    []string `yaml:"folders"`
+	OSDefaults   bool     `yaml:"os_defaults"`
+	IgnoreHidden bool     `yaml:"ignore_hidden"` // dotfiles and dot folders
+	Files        []string `yaml:"files"`
+	Extensions   []string `yaml:"extensions"`
+	Folders      []string `yaml:"folders"`
+	MinSize      string   `yaml:"min_size"`
+	MaxSize      string   `yaml:"max_size"`
+
+	// InProgressSuffixes mark downloads that aren't finished yet. The file
+	// is picked up once the browser renames it to its final name.
+	InProgressSuffixes []string `yaml:"in_progress_suffixes"`
+
+	// Patterns are regexes matched against the path relative to the
+	// watched folder, with "/" separators.
+	Patterns []string `yaml:"patterns"`
+
+	// IgnoreFiles are .gitignore-style files read from each watched
+	// folder, e.g. ".entropyignore" or ".gitignore".
+	IgnoreFiles []string `yaml:"ignore_files"`
+
+	minBytes, maxBytes int64
+	patterns           []*regexp.Regexp
+	ignoreFiles        map[string]*ignoreFile // by watched folder
+}
+
+// isInProgress reports whether name looks like a download that isn't
+// finished yet.
+func isInProgress(name string, cfg IgnoreConfig) bool {
+	for _, suffix := range cfg.InProgressSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSize parses human-readable sizes like "512", "10KB" or "4.5GB".
+// Units are powers of 1024.
+func parseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * mult), nil
 }
 
-func isIgnored(path string, cfg IgnoreConfig) bool {
+// isIgnored reports whether path should be left alone. Folder ignores only
+// look at the folders between root and path, so a watch dir that itself
+// lives under e.g. "tmp" doesn't ignore everything.
+func isIgnored(path, root string, cfg IgnoreConfig) bool {
 	base := filepath.Base(path)
 
 	// ignore prefixed "._"
 	if strings.HasPrefix(base, "._") {
 		return true
 	}
+	if cfg.IgnoreHidden && isHidden(base) {
+		return true
+	}
 
 	// OS defaults
 	if cfg.OSDefaults {
@@ -74,11 +396,18 @@ func isIgnored(path string, cfg IgnoreConfig) bool {
 		}
 	}
 
-	// explicit filenames
+	// explicit filenames or glob patterns like "~$*"
 	for _, ign := range cfg.Files {
 		if base == ign {
 			return true
 		}
+		if ok, _ := filepath.Match(ign, base); ok {
+			return true
+		}
+	}
+
+	if isInProgress(base, cfg) {
+		return true
 	}
 
 	// extensions
@@ -89,34 +418,167 @@ func isIgnored(path string, cfg IgnoreConfig) bool {
 		}
 	}
 
-	// folders
-	for _, folder := range cfg.Folders {
-		if strings.Contains(path, folder) {
+	// size limits
+	if cfg.minBytes > 0 || cfg.maxBytes > 0 {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			if info.Size() < cfg.minBytes || (cfg.maxBytes > 0 && info.Size() > cfg.maxBytes) {
+				return true
+			}
+		}
+	}
+
+	// folders, matched against whole path segments
+	inRoot := true
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel, inRoot = filepath.Dir(path), false
+	}
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isIgnoredFolder(dir, cfg) {
 			return true
 		}
 	}
 
+	// .gitignore-style files of the watched folder, which are never sorted
+	// themselves
+	if file := cfg.ignoreFiles[root]; root != "" && inRoot {
+		relPath := filepath.ToSlash(filepath.Join(rel, base))
+		for _, name := range cfg.IgnoreFiles {
+			if relPath == name {
+				return true
+			}
+		}
+		if file != nil && len(file.rules) > 0 {
+			info, statErr := os.Stat(path)
+			if file.matches(relPath, statErr == nil && info.IsDir()) {
+				return true
+			}
+		}
+	}
+
+	// regexes on the relative path
+	if len(cfg.patterns) > 0 {
+		relPath := filepath.ToSlash(filepath.Join(rel, base))
+		for _, re := range cfg.patterns {
+			if re.MatchString(relPath) {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
-func getGenAIClient(apiKey string) *genai.Client {
-	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+// isIgnoredFolder reports whether a folder named name matches ignore.folders.
+func isIgnoredFolder(name string, cfg IgnoreConfig) bool {
+	if cfg.IgnoreHidden && isHidden(name) {
+		return true
+	}
+	for _, folder := range cfg.Folders {
+		if ok, _ := filepath.Match(folder, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath returns path made absolute and cleaned, or just cleaned if the
+// working directory can't be read.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		log.Fatalf("Failed to create GenAI client: %v", err)
+		return filepath.Clean(path)
 	}
-	return client
+	return abs
+}
+
+// isHidden reports whether name is a dotfile or dot folder.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
 }
 
 var (
 	jobQueue = make(chan Job, 100)
 	limiter  = rate.NewLimiter(rate.Every(3*time.Second), 1)
+	aiCache  *suggestionCache
+	hashes   *hashIndex
+
+	// aiConfig is the config the AI workers use, replaced on reload
+	aiConfig atomic.Pointer[Config]
+
+	// folderListTruncated is set once the folder list had to be cut short,
+	// so it's only reported once
+	folderListTruncated atomic.Bool
+
+	// inflight tracks organizeItem calls so shutdown can wait for them
+	inflight sync.WaitGroup
+	// paths written by organizeItem, so their own events are skipped
+	justWritten = newRecentPaths(recentTTL)
+	// paths an event was just handled for, see handleEvent
+	recentEvents = newRecentPaths(time.Second)
 )
 
-func getFolderStructure(root string) string {
-	var b strings.Builder
+const shutdownTimeout = 10 * time.Second
+
+// shutdown answers queued AI jobs so nobody waits on them and gives
+// in-flight moves shutdownTimeout to finish before forcing an exit.
+func shutdown() {
+drain:
+	for {
+		select {
+		case job := <-jobQueue:
+			job.resultCh <- ""
+		default:
+			break drain
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// files still settling give up once ctx is cancelled
+		handling.Wait()
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Fatalf("Timed out after %v waiting for moves to finish, exiting", shutdownTimeout)
+	}
+}
+
+// newLimiter builds the AI rate limiter, keeping the original one request
+// every 3 seconds when unset.
+func newLimiter(cfg GptConfig) *rate.Limiter {
+	every := rate.Every(3 * time.Second)
+	if cfg.RequestsPerMinute > 0 {
+		every = rate.Limit(cfg.RequestsPerMinute / 60)
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(every, burst)
+}
+
+func watchRecursive(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// getFolderStructure lists the folders under root for the AI prompt,
+// leaving out ignored folders and everything below them. At most maxDepth
+// levels and maxFolders folders are listed, shallower ones first; 0 means
+// no limit.
+func getFolderStructure(root string, ignore IgnoreConfig, maxDepth, maxFolders int) string {
+	var byDepth [][]string
 	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -126,10 +588,40 @@ func getFolderStructure(root string) string {
 			if rel == "." {
 				return nil
 			}
-			b.WriteString(rel + "\n")
+			if isIgnoredFolder(d.Name(), ignore) {
+				return filepath.SkipDir
+			}
+			depth := strings.Count(rel, string(filepath.Separator))
+			if maxDepth > 0 && depth >= maxDepth {
+				return filepath.SkipDir
+			}
+			for len(byDepth) <= depth {
+				byDepth = append(byDepth, nil)
+			}
+			byDepth[depth] = append(byDepth[depth], rel)
 		}
 		return nil
 	})
+
+	var folders []string
+	total := 0
+	for _, level := range byDepth {
+		total += len(level)
+		for _, rel := range level {
+			if maxFolders == 0 || len(folders) < maxFolders {
+				folders = append(folders, rel)
+			}
+		}
+	}
+	if len(folders) < total && !folderListTruncated.Swap(true) {
+		slog.Warn(fmt.Sprintf("Only sending %d of %d folders to the AI; raise gpt.max_folders to include more", len(folders), total))
+	}
+
+	sort.Strings(folders)
+	var b strings.Builder
+	for _, rel := range folders {
+		b.WriteString(rel + "\n")
+	}
 	return b.String()
 }
 
@@ -147,272 +639,1856 @@ func extractImageMetadata(path string) string {
 	return fmt.Sprintf("%v", x)
 }
 
-func getFileMetadata(path string) string {
+// detectContentType sniffs the MIME type from the first bytes of the file,
+// e.g. "application/pdf", or returns "" if it can't be read.
+func detectContentType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := f.Read(buf)
+	if n == 0 || (err != nil && err != io.EOF) {
+		return ""
+	}
+	mime, _, _ := strings.Cut(http.DetectContentType(buf[:n]), ";")
+	return mime
+}
+
+// getFileMetadata describes the file at path for the AI: its type and size
+// and, where possible, a sample of its content.
+func getFileMetadata(path string, cfg GptConfig) string {
 	info, err := os.Stat(path)
 	if err != nil {
 		return ""
 	}
 	ext := strings.ToLower(filepath.Ext(path))
 	size := info.Size()
+	mime := detectContentType(path)
+
+	// trust the content over the extension for misnamed files
+	kind := ext
+	switch {
+	case mime == "application/pdf":
+		kind = ".pdf"
+	case mime == "image/jpeg", mime == "image/png":
+		kind = ".jpg"
+	case mime == "application/zip" && ext == "":
+		kind = ".zip"
+	}
 
-	switch ext {
+	switch kind {
 	case ".txt", ".md", ".csv", ".json", ".html":
 		snippet := getFileContentSnippet(path, 500)
-		return fmt.Sprintf("Extension: %s, Size: %d bytes, Snippet: %q", ext, size, snippet)
+		return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes, Snippet: %q", ext, mime, size, snippet)
 	case ".pdf":
 		text := extractPDFText(path)
-		return fmt.Sprintf("Extension: %s, Size: %d bytes, Content: %q", ext, size, text)
+		return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes, Content: %q", ext, mime, size, text)
 	case ".jpg", ".jpeg", ".png":
 		meta := extractImageMetadata(path)
-		return fmt.Sprintf("Extension: %s, Size: %d bytes, Metadata: %q", ext, size, meta)
+		return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes, Metadata: %q", ext, mime, size, meta)
+	case ".zip":
+		if !cfg.PeekArchives || (cfg.archiveMaxSize > 0 && size > cfg.archiveMaxSize) {
+			return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes", ext, mime, size)
+		}
+		contents := describeZip(path, cfg.ArchiveMaxEntries)
+		return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes, Contains: %s", ext, mime, size, contents)
 	default:
-		return fmt.Sprintf("Extension: %s, Size: %d bytes", ext, size)
+		return fmt.Sprintf("Extension: %s, MIME: %s, Size: %d bytes", ext, mime, size)
 	}
 }
 
-func suggestFolderWithGenAI(ctx context.Context, client *genai.Client, modelName, instructions, knowledge string, preserve bool) {
-	go func() {
-		for job := range jobQueue {
-			if err := limiter.Wait(ctx); err != nil {
-				log.Println("Rate limiter error:", err)
-				job.resultCh <- "Unsorted"
-				continue
-			}
-
-			folders := getFolderStructure("entropy")
-			metadata := getFileMetadata(job.filename)
-
-			prompt := fmt.Sprintf(`%s
-
-Knowledge base:
-%s
-
-Filename: %s
-Metadata: %s
-Existing folder structure: %s
-
-Constraints:
-- Respond only with a folder path.
-- %s`,
-				instructions,
-				knowledge,
-				filepath.Base(job.filename),
-				metadata,
-				folders,
-				func() string {
-					if preserve {
-						return "Do not suggest new folders. Only pick from existing ones."
-					}
-					return "You may suggest new folders if appropriate."
-				}(),
-			)
-
-			log.Println("Prompt:\n", prompt)
-
-			resp, err := client.Models.GenerateContent(ctx, modelName, genai.Text(prompt), nil)
-			if err != nil {
-				log.Println("GenAI error:", err)
-				job.resultCh <- "Unsorted"
-				continue
-			}
-
-			text := strings.TrimSpace(resp.Text())
-			if text == "" {
-				job.resultCh <- "Unsorted"
-			} else {
-				job.resultCh <- text
-			}
-		}
-	}()
-}
+// loadConfig reads, normalizes and validates the config at path. override,
+// if not nil, is applied right after parsing so command-line flags take
+// precedence over the file while defaults still fill in whatever is unset.
+func loadConfig(path string, override func(*Config)) (Config, error) {
+	var config Config
+	var files []string
+	if err := readConfigFile(path, &config, &files); err != nil {
+		return Config{}, err
+	}
+	config.files = files
+	expandEnv(&config)
+	if override != nil {
+		override(&config)
+	}
+	if config.Options.RulesOnly {
+		config.Gpt.Enabled = false
+	}
 
-func loadConfig(path string) Config {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Fatalf("couldn't open file %s: %v", path, err)
+	// watch_dir is kept as a shorthand for a single entry in watch_dirs
+	if config.Options.WatchDir != "" {
+		config.Options.WatchDirs = append([]string{config.Options.WatchDir}, config.Options.WatchDirs...)
+	}
+	// fall back to the original "entropy" folder when not configured
+	if len(config.Options.WatchDirs) == 0 {
+		config.Options.WatchDirs = []string{defaultDir}
+	}
+	// event paths are compared against these, so however they were
+	// spelled, e.g. "./inbox/" or a relative path, they must match
+	for i, dir := range config.Options.WatchDirs {
+		config.Options.WatchDirs[i] = absPath(dir)
+	}
+	if config.Options.OutputDir == "" {
+		config.Options.OutputDir = config.Options.WatchDirs[0]
+	}
+	config.Options.OutputDir = absPath(config.Options.OutputDir)
+	if config.Options.DuplicatesFolder == "" {
+		config.Options.DuplicatesFolder = "Duplicates"
+	}
+	if config.Options.FallbackFolder == "" {
+		config.Options.FallbackFolder = "Unsorted"
+	}
+	if config.Options.StalledFolder == "" {
+		config.Options.StalledFolder = "Stalled"
+	}
+	if config.Quarantine.Folder == "" {
+		config.Quarantine.Folder = "Quarantine"
+	}
+	if config.Options.EmptyFolder == "" {
+		config.Options.EmptyFolder = "Empty"
+	}
+	if config.Ignore.InProgressSuffixes == nil {
+		config.Ignore.InProgressSuffixes = []string{".crdownload", ".part", ".partial", ".download", ".opdownload"}
+	}
+	if config.Options.HashIndex == "" {
+		config.Options.HashIndex = filepath.Join(config.Options.OutputDir, hashIndexName)
 	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		log.Fatalf("Invalid YAML: %v", err)
+	if err := validateConfig(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid config %s:\n%w", path, err)
 	}
 
-	return config
+	return config, nil
 }
 
-func loadKnowledgeBase(path string) string {
-	if path == "" {
-		return ""
+// expandEnv replaces $VAR and ${VAR} in the config's paths and secrets, so
+// e.g. the API key can stay out of the file.
+func expandEnv(config *Config) {
+	for _, field := range []*string{
+		&config.Gpt.ApiKey,
+		&config.Gpt.ApiKeyFile,
+		&config.Options.KnowledgeBase,
+		&config.Options.WatchDir,
+		&config.Options.OutputDir,
+		&config.Options.DuplicatesFolder,
+		&config.Options.TrashFolder,
+		&config.Options.FallbackFolder,
+		&config.Options.StalledFolder,
+		&config.Options.EmptyFolder,
+		&config.Quarantine.Folder,
+		&config.Options.HashIndex,
+		&config.Options.IndexDB,
+		&config.Gpt.CacheFile,
+	} {
+		*field = os.ExpandEnv(*field)
 	}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Printf("Could not read knowledge base %s: %v", path, err)
-		return ""
+	for i := range config.Options.WatchDirs {
+		config.Options.WatchDirs[i] = os.ExpandEnv(config.Options.WatchDirs[i])
 	}
-	return string(data)
-}
-
-func matchRules(filename string, rules []Rule) string {
-	for _, rule := range rules {
-		re := regexp.MustCompile(rule.Pattern)
-		if re.MatchString(filename) {
-			return rule.Target
+	// rule targets may point outside output_dir, e.g. "~/Documents/Accounting"
+	for i := range config.Rules {
+		target := os.ExpandEnv(config.Rules[i].Target)
+		if rest, ok := strings.CutPrefix(target, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				target = filepath.Join(home, rest)
+			}
 		}
+		config.Rules[i].Target = target
 	}
-	return ""
 }
 
-func organizeItem(srcPath, targetFolder string, preserve bool) {
-	base := filepath.Base(srcPath)
-	destDir := filepath.Join("entropy", targetFolder)
+// validateConfig checks config for problems and prepares its parsed fields.
+// All problems are reported together so they can be fixed in one pass.
+func validateConfig(config *Config) error {
+	var errs []error
+	var err error
 
-	if preserve {
-		// check if folder exists before moving
-		if _, err := os.Stat(destDir); os.IsNotExist(err) {
-			log.Printf("Skipping %s → %s (preserve_structure=true, folder doesn't exist)", base, destDir)
-			return
+	if err := compileRules(config.Rules); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch config.Gpt.Provider {
+	case "", "gemini", "openai":
+	default:
+		errs = append(errs, fmt.Errorf("invalid gpt.provider %q: must be gemini or openai", config.Gpt.Provider))
+	}
+	// api_key wins over api_key_file, which wins over the provider's
+	// environment variable
+	keyEnv := "GEMINI_API_KEY"
+	if config.Gpt.Provider == "openai" {
+		keyEnv = "OPENAI_API_KEY"
+	}
+	switch {
+	case config.Gpt.ApiKey != "":
+	case config.Gpt.ApiKeyFile != "":
+		data, err := os.ReadFile(config.Gpt.ApiKeyFile)
+		config.Gpt.ApiKey = strings.TrimSpace(string(data))
+		if err != nil && config.Gpt.Enabled {
+			errs = append(errs, fmt.Errorf("could not read gpt.api_key_file: %w", err))
+		} else if config.Gpt.Enabled && config.Gpt.ApiKey == "" {
+			errs = append(errs, fmt.Errorf("gpt is enabled but gpt.api_key_file %s is empty", config.Gpt.ApiKeyFile))
 		}
-	} else {
-		if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
-			log.Printf("Failed to create dir %s: %v", destDir, err)
-			return
+	default:
+		config.Gpt.ApiKey = os.Getenv(keyEnv)
+		if config.Gpt.Enabled && config.Gpt.ApiKey == "" {
+			errs = append(errs, fmt.Errorf("gpt is enabled but no API key is set: use gpt.api_key, gpt.api_key_file or %s", keyEnv))
 		}
 	}
-
-	destPath := filepath.Join(destDir, base)
-
-	if _, err := os.Stat(destPath); err == nil {
-		ext := filepath.Ext(base)
-		name := strings.TrimSuffix(base, ext)
-
-		for i := 1; ; i++ {
-			newName := fmt.Sprintf("%s - %d%s", name, i, ext)
-			newPath := filepath.Join(destDir, newName)
-
-			if _, err := os.Stat(newPath); os.IsNotExist(err) {
-				destPath = newPath
-				break
-			}
+	for _, folder := range config.Gpt.AllowedFolders {
+		if clean, ok := sanitizeFolder(folder, config.Options.OutputDir); !ok || clean != filepath.Clean(folder) {
+			errs = append(errs, fmt.Errorf("invalid gpt.allowed_folders entry %q: must be a relative path inside output_dir", folder))
 		}
 	}
-
-	if err := os.Rename(srcPath, destPath); err != nil {
-		log.Printf("Failed to move %s: %v", base, err)
-		return
+	switch config.Gpt.OnFatalError {
+	case "", "disable", "continue":
+	default:
+		errs = append(errs, fmt.Errorf("invalid gpt.on_fatal_error %q: must be disable or continue", config.Gpt.OnFatalError))
 	}
-
-	log.Printf("Moved %s → %s", base, destPath)
-}
-
-func getFileContentSnippet(path string, limit int) string {
-	f, err := os.Open(path)
-	if err != nil {
-		return ""
+	if config.Gpt.Cooldown != "" {
+		if config.Gpt.cooldown, err = time.ParseDuration(config.Gpt.Cooldown); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.cooldown: %w", err))
+		}
 	}
-
-	defer f.Close()
-
-	buf := make([]byte, limit)
-	n, _ := f.Read(buf)
-	return string(buf[:n])
-}
-
-func extractPDFText(path string) string {
-	f, r, err := pdf.Open(path)
-	if err != nil {
-		return ""
+	if config.Gpt.QueueSize == 0 {
+		config.Gpt.QueueSize = 100
 	}
-	defer f.Close()
-	var buf bytes.Buffer
-	b, err := r.GetPlainText()
-	if err == nil {
-		io.Copy(&buf, b)
+	if config.Gpt.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("invalid gpt.queue_size %d: can't be negative", config.Gpt.QueueSize))
 	}
-	text := buf.String()
-	if len(text) > 500 {
-		text = text[:500] + "..."
+	switch config.Gpt.QueueFull {
+	case "", "block", "fallback", "reject":
+	default:
+		errs = append(errs, fmt.Errorf("invalid gpt.queue_full %q: must be block, fallback or reject", config.Gpt.QueueFull))
 	}
-	return text
-}
-
-func main() {
-	os.MkdirAll("entropy", os.ModePerm)
-
-	config := loadConfig("rules.yaml")
-	// TODO: add a config to determine the folder to watch
-	knowledge := loadKnowledgeBase(config.Options.KnowledgeBase)
-
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
+	config.Gpt.activeHours = nil
+	if config.Gpt.ActiveHours != "" {
+		if config.Gpt.activeHours, err = parseActiveHours(config.Gpt.ActiveHours); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.active_hours: %w", err))
+		}
 	}
-
-	defer watcher.Close()
-	err = watcher.Add("entropy")
-
-	if err != nil {
-		log.Fatal(err)
+	config.Gpt.location = time.Local
+	if config.Gpt.TimeZone != "" {
+		if config.Gpt.location, err = time.LoadLocation(config.Gpt.TimeZone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.timezone: %w", err))
+		}
 	}
-
-	var client *genai.Client
-	if config.Gpt.Enabled {
-		client = getGenAIClient(config.Gpt.ApiKey)
-		suggestFolderWithGenAI(
-			context.Background(),
-			client,
-			config.Gpt.Model,
-			config.Gpt.Instructions,
-			knowledge,
-			config.Options.PreserveStructure,
-		)
+	switch config.Gpt.OutsideHours {
+	case "", "fallback", "wait":
+	default:
+		errs = append(errs, fmt.Errorf("invalid gpt.outside_hours %q: must be fallback or wait", config.Gpt.OutsideHours))
 	}
-
-	log.Println("Watching 'entropy' folder...")
-
+	if config.Gpt.CooldownRate == 0 {
+		config.Gpt.CooldownRate = 0.9
+	}
+	if config.Gpt.CooldownRate < 0 || config.Gpt.CooldownRate > 1 {
+		errs = append(errs, fmt.Errorf("invalid gpt.cooldown_rate %v: must be between 0 and 1", config.Gpt.CooldownRate))
+	}
+	if config.Gpt.CooldownWindow == 0 {
+		config.Gpt.CooldownWindow = 20
+	}
+	if config.Gpt.CooldownWindow < 0 {
+		errs = append(errs, fmt.Errorf("invalid gpt.cooldown_window %d: can't be negative", config.Gpt.CooldownWindow))
+	}
+	if config.Gpt.MinConfidence < 0 || config.Gpt.MinConfidence > 1 {
+		errs = append(errs, fmt.Errorf("invalid gpt.min_confidence %v: must be between 0 and 1", config.Gpt.MinConfidence))
+	}
+	if config.Gpt.ArchiveMaxEntries == 0 {
+		config.Gpt.ArchiveMaxEntries = 1000
+	}
+	config.Gpt.archiveMaxSize = 1 << 30
+	if config.Gpt.ArchiveMaxSize != "" {
+		if config.Gpt.archiveMaxSize, err = parseSize(config.Gpt.ArchiveMaxSize); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.archive_max_size: %w", err))
+		}
+	}
+	config.Gpt.maxKnowledge = 32 << 10
+	if config.Gpt.MaxKnowledge != "" {
+		if config.Gpt.maxKnowledge, err = parseSize(config.Gpt.MaxKnowledge); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.max_knowledge: %w", err))
+		}
+	}
+	if config.Gpt.MaxFolderDepth < 0 || config.Gpt.MaxFolders < 0 {
+		errs = append(errs, errors.New("gpt.max_folder_depth and gpt.max_folders can't be negative"))
+	}
+	config.Gpt.batchWindow = 2 * time.Second
+	if config.Gpt.BatchWindow != "" {
+		if config.Gpt.batchWindow, err = time.ParseDuration(config.Gpt.BatchWindow); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.batch_window: %w", err))
+		}
+	}
+	if config.Gpt.CacheTTL != "" {
+		if config.Gpt.cacheTTL, err = time.ParseDuration(config.Gpt.CacheTTL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gpt.cache_ttl: %w", err))
+		}
+	}
+
+	if config.Ignore.MinSize != "" {
+		if config.Ignore.minBytes, err = parseSize(config.Ignore.MinSize); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ignore.min_size: %w", err))
+		}
+	}
+	if config.Ignore.MaxSize != "" {
+		if config.Ignore.maxBytes, err = parseSize(config.Ignore.MaxSize); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ignore.max_size: %w", err))
+		}
+	}
+	for _, pattern := range append(config.Ignore.Files, config.Ignore.Folders...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err))
+		}
+	}
+	config.Ignore.patterns = nil
+	for _, pattern := range config.Ignore.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid ignore.patterns entry %q: %w", pattern, err))
+			continue
+		}
+		config.Ignore.patterns = append(config.Ignore.patterns, re)
+	}
+	config.Ignore.ignoreFiles = nil
+	if len(config.Ignore.IgnoreFiles) > 0 {
+		config.Ignore.ignoreFiles = make(map[string]*ignoreFile)
+		for _, dir := range config.Options.WatchDirs {
+			merged := &ignoreFile{}
+			for _, name := range config.Ignore.IgnoreFiles {
+				file, err := loadIgnoreFile(filepath.Join(dir, name))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("could not read ignore file: %w", err))
+					continue
+				}
+				merged.rules = append(merged.rules, file.rules...)
+			}
+			config.Ignore.ignoreFiles[dir] = merged
+		}
+	}
+
+	switch config.Options.OnConflict {
+	case "", "rename", "skip", "overwrite":
+	default:
+		errs = append(errs, fmt.Errorf("invalid on_conflict %q: must be rename, skip or overwrite", config.Options.OnConflict))
+	}
+	switch config.Options.OnDuplicate {
+	case "", "skip", "move":
+	default:
+		errs = append(errs, fmt.Errorf("invalid on_duplicate %q: must be skip or move", config.Options.OnDuplicate))
+	}
+	switch config.Options.OnEmpty {
+	case "", "sort", "skip", "move":
+	default:
+		errs = append(errs, fmt.Errorf("invalid on_empty %q: must be sort, skip or move", config.Options.OnEmpty))
+	}
+	switch config.Options.OnStalled {
+	case "", "skip", "process", "move":
+	default:
+		errs = append(errs, fmt.Errorf("invalid on_stalled %q: must be skip, process or move", config.Options.OnStalled))
+	}
+	config.Options.settleInterval, config.Options.settleTimeout = 500*time.Millisecond, 30*time.Second
+	if config.Options.SettleInterval != "" {
+		if config.Options.settleInterval, err = time.ParseDuration(config.Options.SettleInterval); err != nil || config.Options.settleInterval <= 0 {
+			errs = append(errs, fmt.Errorf("invalid settle_interval %q: must be a positive duration", config.Options.SettleInterval))
+		}
+	}
+	if config.Options.SettleTimeout != "" {
+		if config.Options.settleTimeout, err = time.ParseDuration(config.Options.SettleTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("invalid settle_timeout: %w", err))
+		}
+	}
+	config.Options.fileTimeout = 0
+	if config.Options.FileTimeout != "" {
+		if config.Options.fileTimeout, err = time.ParseDuration(config.Options.FileTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("invalid file_timeout: %w", err))
+		}
+	}
+	if config.Options.SummaryInterval != "" {
+		if config.Options.summaryInterval, err = time.ParseDuration(config.Options.SummaryInterval); err != nil {
+			errs = append(errs, fmt.Errorf("invalid summary_interval: %w", err))
+		}
+	}
+	config.extensions = make(map[string]string)
+	targets := make([]string, 0, len(config.ExtensionMap))
+	for target := range config.ExtensionMap {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		for _, ext := range config.ExtensionMap[target] {
+			ext = normalizeExt(ext)
+			if ext == "" || ext == "." {
+				errs = append(errs, fmt.Errorf("extension_map %q has an empty extension", target))
+				continue
+			}
+			if other, ok := config.extensions[ext]; ok {
+				errs = append(errs, fmt.Errorf("extension_map lists %s under both %q and %q", ext, other, target))
+				continue
+			}
+			config.extensions[ext] = target
+		}
+	}
+	config.Quarantine.extensions = make(map[string]bool)
+	for _, ext := range config.Quarantine.Extensions {
+		if ext = normalizeExt(ext); ext == "" || ext == "." {
+			errs = append(errs, fmt.Errorf("quarantine.extensions has an empty extension"))
+			continue
+		}
+		config.Quarantine.extensions[ext] = true
+	}
+	for _, pattern := range config.Quarantine.Allow {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid quarantine.allow pattern %q: %w", pattern, err))
+		}
+	}
+	config.Classifier.timeout = 10 * time.Second
+	if config.Classifier.Timeout != "" {
+		if config.Classifier.timeout, err = time.ParseDuration(config.Classifier.Timeout); err != nil || config.Classifier.timeout <= 0 {
+			errs = append(errs, fmt.Errorf("invalid classifier.timeout %q: must be a positive duration", config.Classifier.Timeout))
+		}
+	}
+	switch config.Classifier.Order {
+	case "", "before_ai", "after_ai":
+	default:
+		errs = append(errs, fmt.Errorf("invalid classifier.order %q: must be before_ai or after_ai", config.Classifier.Order))
+	}
+	for kind, template := range config.Options.RouteTemplates {
+		switch kind {
+		case "quarantine", "rule", "extension", "marker", "classifier", "ai", "fallback", "duplicate":
+		default:
+			errs = append(errs, fmt.Errorf("invalid route_templates key %q: must be quarantine, rule, extension, marker, classifier, ai, fallback or duplicate", kind))
+		}
+		if !strings.Contains(template, "{target}") {
+			errs = append(errs, fmt.Errorf("route_templates.%s %q must contain {target}", kind, template))
+		}
+	}
+	if config.Options.MoveWorkers < 0 {
+		errs = append(errs, fmt.Errorf("invalid move_workers %d: can't be negative", config.Options.MoveWorkers))
+	}
+	if config.Options.MaxDepth < 0 {
+		errs = append(errs, fmt.Errorf("invalid max_depth %d: can't be negative", config.Options.MaxDepth))
+	}
+	config.Options.debounce = time.Second
+	if config.Options.Debounce != "" {
+		if config.Options.debounce, err = time.ParseDuration(config.Options.Debounce); err != nil || config.Options.debounce < 0 {
+			errs = append(errs, fmt.Errorf("invalid debounce %q: must be a duration", config.Options.Debounce))
+		}
+	}
+	if config.Options.PollInterval != "" {
+		if config.Options.pollInterval, err = time.ParseDuration(config.Options.PollInterval); err != nil || config.Options.pollInterval <= 0 {
+			errs = append(errs, fmt.Errorf("invalid poll_interval %q: must be a positive duration", config.Options.PollInterval))
+		}
+	}
+	config.Options.dirMode = 0o755
+	if config.Options.DirMode != "" {
+		if config.Options.dirMode, err = parseFileMode(config.Options.DirMode); err != nil {
+			errs = append(errs, fmt.Errorf("invalid dir_mode: %w", err))
+		}
+	}
+	config.Options.fileMode = 0
+	if config.Options.FileMode != "" {
+		if config.Options.fileMode, err = parseFileMode(config.Options.FileMode); err != nil {
+			errs = append(errs, fmt.Errorf("invalid file_mode: %w", err))
+		}
+	}
+	switch config.Options.Mode {
+	case "", "move", "copy", "review":
+	default:
+		errs = append(errs, fmt.Errorf("invalid mode %q: must be move, copy or review", config.Options.Mode))
+	}
+	switch config.Options.LogFormat {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("invalid log_format %q: must be text or json", config.Options.LogFormat))
+	}
+	if config.Options.LogLevel != "" {
+		if err := config.Options.logLevel.UnmarshalText([]byte(config.Options.LogLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid log_level %q: must be debug, info, warn or error", config.Options.LogLevel))
+		}
+	}
+	config.Options.events = 0
+	for _, name := range config.Options.Events {
+		switch name {
+		case "create":
+			config.Options.events |= fsnotify.Create
+		case "rename":
+			config.Options.events |= fsnotify.Rename
+		case "write":
+			config.Options.events |= fsnotify.Write
+		default:
+			errs = append(errs, fmt.Errorf("invalid event %q: must be create, rename or write", name))
+		}
+	}
+	if config.Options.events == 0 {
+		config.Options.events = fsnotify.Create
+	}
+	switch config.Options.UnresolvedTokens {
+	case "", "keep", "unsorted":
+	default:
+		errs = append(errs, fmt.Errorf("invalid unresolved_tokens %q: must be keep or unsorted", config.Options.UnresolvedTokens))
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadKnowledgeBase reads the knowledge base file, or every text file in it
+// when path is a folder.
+func loadKnowledgeBase(path string, maxBytes int64) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		return loadKnowledgeDir(path, maxBytes), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read knowledge base %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// loadKnowledgeDir joins the text files under dir, each under a heading
+// with its name. Files past maxBytes aren't read at all; 0 means no limit.
+func loadKnowledgeDir(dir string, maxBytes int64) string {
+	var b strings.Builder
+	skipped := 0
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if maxBytes > 0 && int64(b.Len()) >= maxBytes {
+			skipped++
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Could not read knowledge file %s: %v", path, err))
+			return nil
+		}
+		if !utf8.Valid(data) || !strings.HasPrefix(http.DetectContentType(data), "text/") {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", filepath.ToSlash(rel), strings.TrimSpace(string(data)))
+		return nil
+	})
+	if skipped > 0 {
+		slog.Warn(fmt.Sprintf("Knowledge base %s is over %s, skipped %d file(s); raise gpt.max_knowledge to include them",
+			dir, formatSize(maxBytes), skipped))
+	}
+	return b.String()
+}
+
+// matchExtension returns the extension_map target for filename, or "".
+func matchExtension(filename string, extensions map[string]string) string {
+	return extensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// ruleMatch is what the matching rules decided for a file.
+type ruleMatch struct {
+	target string
+	rule   int   // index of the rule that set target, -1 if none did
+	rules  []int // indexes of every matching rule, in order
+	action string
+	rename string
+	hints  []string
+	then   []string
+}
+
+// matchRules checks the rules against the file at path in priority order,
+// see compileRules, until one without continue matches. Each matching
+// rule adds its post-actions; the last target and action set win. Rules
+// with a mime only match files whose detected content type fits it. ok is
+// false if no rule matched.
+func matchRules(path string, rules []Rule) (match ruleMatch, ok bool) {
+	match.rule = -1
+	filename := filepath.Base(path)
+	mime, detected := "", false
+	var info os.FileInfo
+	statted := false
+	for i, rule := range rules {
+		if rule.Mime != "" {
+			if !detected {
+				mime, detected = detectContentType(path), true
+			}
+			if ok, _ := filepath.Match(rule.Mime, mime); !ok || mime == "" {
+				continue
+			}
+		}
+		if rule.checksInfo() {
+			if !statted {
+				info, _ = os.Stat(path)
+				statted = true
+			}
+			if !ruleMatchesInfo(rule, path, info) {
+				continue
+			}
+		}
+		if !ruleMatchesName(rule, filename) {
+			continue
+		}
+
+		match.rules = append(match.rules, i)
+		if rule.Target != "" {
+			match.target, match.rule = rule.Target, i
+		}
+		if rule.Action != "" {
+			match.action = rule.Action
+		}
+		if rule.Rename != "" {
+			match.rename = rule.Rename
+		}
+		if rule.Hint != "" {
+			match.hints = append(match.hints, rule.Hint)
+		}
+		match.then = append(match.then, rule.Then...)
+		if !rule.Continue {
+			break
+		}
+	}
+	return match, len(match.rules) > 0
+}
+
+// checksInfo reports whether rule has conditions on the file's age or
+// size, which need it to be stat'ed.
+func (rule Rule) checksInfo() bool {
+	return rule.olderThan > 0 || rule.minBytes > 0 || rule.maxBytes > 0
+}
+
+// ruleMatchesInfo checks the age and size conditions of rule against the
+// file at path. Files that can't be stat'ed don't match; folders count
+// everything inside them.
+func ruleMatchesInfo(rule Rule, path string, info os.FileInfo) bool {
+	if info == nil {
+		return false
+	}
+	if rule.olderThan > 0 && time.Since(info.ModTime()) < rule.olderThan {
+		return false
+	}
+	size := info.Size()
+	if info.IsDir() && (rule.minBytes > 0 || rule.maxBytes > 0) {
+		size, _, _ = statTree(path)
+	}
+	if size < rule.minBytes || (rule.maxBytes > 0 && size > rule.maxBytes) {
+		return false
+	}
+	return true
+}
+
+// ruleMatchesName reports whether the pattern of rule matches filename.
+// Rules without a pattern match every name.
+func ruleMatchesName(rule Rule, filename string) bool {
+	switch {
+	case rule.Pattern == "":
+		return true
+	case rule.Type == "glob":
+		pattern, name := rule.Pattern, filename
+		if rule.CaseInsensitive {
+			pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+		}
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	default:
+		return rule.re.MatchString(filename)
+	}
+}
+
+// organizeItem moves srcPath into targetFolder under the output dir, or
+// into targetFolder itself if it's an absolute path, and returns the final
+// destination path, or "" if the file was not moved.
+// decidedBy records how the target was chosen, e.g. "rule 2" or "ai".
+// action is "move", "copy", "symlink" or "delete"; "" uses options.mode.
+// rename is a template for the new name, "" keeps it.
+func organizeItem(srcPath, targetFolder, decidedBy, action, rename string, opts Options) string {
+	base := filepath.Base(srcPath)
+	if action == "" {
+		action = opts.Mode
+	}
+	trashing := action == "delete" && opts.TrashFolder != ""
+	if action == "delete" && !trashing {
+		deleteItem(srcPath, decidedBy, opts)
+		return ""
+	}
+	if trashing {
+		targetFolder, action = opts.TrashFolder, "move"
+	}
+	if action == "move" && opts.Mode == "review" {
+		action = "review"
+	}
+
+	targetFolder = expandTarget(targetFolder, srcPath, opts)
+	destDir, root := filepath.Clean(targetFolder), filepath.Dir(filepath.Clean(targetFolder))
+	if !filepath.IsAbs(targetFolder) {
+		targetFolder = clampDepth(targetFolder, opts.MaxDepth)
+		if opts.SlugifyFolders {
+			targetFolder = slugifyFolder(targetFolder)
+		}
+		destDir, root = filepath.Join(opts.OutputDir, targetFolder), opts.OutputDir
+	}
+
+	if opts.PreserveStructure && !trashing {
+		// check if folder exists before moving
+		if _, err := os.Stat(destDir); os.IsNotExist(err) {
+			slog.Info(fmt.Sprintf("Skipping %s → %s (preserve_structure=true, folder doesn't exist)", base, destDir),
+				"event", "skipped", "src", srcPath, "dest", destDir, "reason", "preserve_structure")
+			return ""
+		}
+	} else if !opts.DryRun {
+		justWritten.add(destDir, root)
+		if err := os.MkdirAll(destDir, opts.dirMode); err != nil {
+			slog.Error(fmt.Sprintf("Failed to create dir %s: %v", destDir, err))
+			return ""
+		}
+	}
+
+	name := base
+	if rename != "" && !trashing {
+		name = renameBase(rename, srcPath, opts)
+	}
+	if dest := filepath.Join(destDir, name); !strings.Contains(name, counterToken) && alreadyInPlace(srcPath, dest, action) {
+		slog.Info(fmt.Sprintf("Skipping %s → %s (already there)", base, dest),
+			"event", "skipped", "src", srcPath, "dest", dest, "reason", "in_place")
+		return ""
+	}
+	destPath, ok := reserveDest(destDir, name, opts.OnConflict)
+	if !ok {
+		slog.Info(fmt.Sprintf("Skipping %s → %s (on_conflict=skip, file exists)", base, destPath),
+			"event", "skipped", "src", srcPath, "dest", destPath, "reason", "on_conflict")
+		return ""
+	}
+	defer releaseDest(destPath)
+
+	copyWithMode := func(src, dst string) error {
+		return copyPath(src, dst, opts.fileMode)
+	}
+	verb, done, transfer := "move", "Moved", moveFile
+	switch action {
+	case "copy":
+		verb, done, transfer = "copy", "Copied", copyWithMode
+	case "symlink":
+		verb, done, transfer = "symlink", "Linked", symlinkPath
+	case "review":
+		// the original stays until "entropy approve"
+		verb, done, transfer = "copy", "Copied for review", copyWithMode
+	}
+
+	if opts.DryRun {
+		slog.Info(fmt.Sprintf("Would %s %s → %s", verb, base, destPath), "event", "would_"+verb, "src", srcPath, "dest", destPath)
+		plan.add(planEntry{Src: srcPath, Target: destPath, DecidedBy: decidedBy, Action: verb})
+		runStats.recordOrganized(targetFolder, decidedBy, fileSize(srcPath))
+		return ""
+	}
+
+	justWritten.add(destPath, root)
+	err := transfer(srcPath, destPath)
+	// events from a long copy may still be queued, so restart the clock
+	justWritten.add(destPath, root)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to %s %s: %v", verb, base, err), "event", verb+"_failed", "src", srcPath, "dest", destPath, "error", err)
+		runStats.recordFailure()
+		metrics.movesFailed.Add(1)
+		return ""
+	}
+	metrics.movesSucceeded.Add(1)
+
+	if action == "review" {
+		recordPending(opts, srcPath, destPath)
+	} else {
+		recordUndo(opts, srcPath, destPath, verb)
+	}
+	runStats.recordOrganized(targetFolder, decidedBy, fileSize(destPath))
+	slog.Info(fmt.Sprintf("%s %s → %s", done, base, destPath), "event", strings.ToLower(done), "src", srcPath, "dest", destPath)
+	return destPath
+}
+
+// deleteItem removes srcPath for rules with action "delete" when no
+// trash_folder is set. With safe_delete it goes to the system trash instead.
+func deleteItem(srcPath, decidedBy string, opts Options) {
+	base := filepath.Base(srcPath)
+	size := fileSize(srcPath)
+	verb, remove := "delete", os.RemoveAll
+	if opts.SafeDelete {
+		verb, remove = "trash", moveToTrash
+	}
+	if opts.DryRun {
+		slog.Info(fmt.Sprintf("Would %s %s", verb, base), "event", "would_"+verb, "src", srcPath)
+		plan.add(planEntry{Src: srcPath, DecidedBy: decidedBy, Action: verb})
+		runStats.recordOrganized("(deleted)", decidedBy, size)
+		return
+	}
+	if err := remove(srcPath); err != nil {
+		slog.Error(fmt.Sprintf("Failed to %s %s: %v", verb, base, err), "event", verb+"_failed", "src", srcPath, "error", err)
+		runStats.recordFailure()
+		metrics.movesFailed.Add(1)
+		return
+	}
+	metrics.movesSucceeded.Add(1)
+	runStats.recordOrganized("(deleted)", decidedBy, size)
+	if opts.SafeDelete {
+		slog.Info("Moved to trash: "+base, "event", "trashed", "src", srcPath)
+	} else {
+		slog.Info("Deleted "+base, "event", "deleted", "src", srcPath)
+	}
+}
+
+var tokenPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// expandTarget replaces metadata tokens in target, see fileTokens.
+// Targets without tokens are returned unchanged. fallback_folder is used when the
+// date can't be read, or when a token is left unresolved and
+// unresolved_tokens is "unsorted".
+func expandTarget(target, path string, opts Options) string {
+	if !strings.Contains(target, "{") {
+		return target
+	}
+
+	pairs, err := fileTokens(target, path)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not read date of %s: %v", path, err))
+		return opts.FallbackFolder
+	}
+	expanded := strings.NewReplacer(pairs...).Replace(target)
+	if left := tokenPattern.FindString(expanded); left != "" && opts.UnresolvedTokens == "unsorted" {
+		slog.Warn(fmt.Sprintf("Unresolved token %s in target %q for %s", left, target, filepath.Base(path)))
+		return opts.FallbackFolder
+	}
+	return expanded
+}
+
+// fileTokens returns replacer pairs for the tokens used in template:
+// {year}, {month}, {day} and {date} (2006-01-02) from the file's
+// modification date, {name} and {ext} from its filename, and {mime} from
+// its detected content type. {taken_year}, {taken_month} and {taken_day}
+// prefer the date a photo was taken. The error is set when the date is
+// needed but can't be read.
+func fileTokens(template, path string) ([]string, error) {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	pairs := []string{"{name}", strings.TrimSuffix(base, ext)}
+	if ext != "" {
+		pairs = append(pairs, "{ext}", strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+
+	if strings.Contains(template, "{mime}") {
+		if mime := detectContentType(path); mime != "" {
+			pairs = append(pairs, "{mime}", mime)
+		}
+	}
+
+	if strings.Contains(template, "{year}") || strings.Contains(template, "{month}") ||
+		strings.Contains(template, "{day}") || strings.Contains(template, "{date}") {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		mod := info.ModTime()
+		pairs = append(pairs,
+			"{year}", mod.Format("2006"),
+			"{month}", mod.Format("01"),
+			"{day}", mod.Format("02"),
+			"{date}", mod.Format("2006-01-02"),
+		)
+	}
+
+	// {taken_*} prefer the date a photo was taken, falling back to the
+	// modification date
+	if strings.Contains(template, "{taken_") {
+		taken, ok := photoTakenAt(path)
+		if !ok {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			taken = info.ModTime()
+		}
+		pairs = append(pairs,
+			"{taken_year}", taken.Format("2006"),
+			"{taken_month}", taken.Format("01"),
+			"{taken_day}", taken.Format("02"),
+		)
+	}
+	return pairs, nil
+}
+
+// clampDepth cuts target down to its first maxDepth folders, so deep
+// suggestions land in their top-level folder instead. 0 means no limit.
+func clampDepth(target string, maxDepth int) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(target)), "/")
+	if maxDepth <= 0 || len(parts) <= maxDepth {
+		return target
+	}
+	clamped := filepath.Join(parts[:maxDepth]...)
+	slog.Info(fmt.Sprintf("Target %s is deeper than max_depth %d, using %s", target, maxDepth, clamped),
+		"event", "depth_clamped", "target", clamped)
+	return clamped
+}
+
+// parseAge parses durations like "30d", "2w" or "12h"; d and w are days
+// and weeks, anything else goes to time.ParseDuration.
+func parseAge(age string) (time.Duration, error) {
+	s := strings.TrimSpace(age)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			days, err := strconv.ParseFloat(n, 64)
+			if err != nil || days <= 0 {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+			return time.Duration(days * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return d, nil
+}
+
+// parseFileMode parses an octal permission string like "0750" or "750".
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q must be octal permissions like 0755", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// slugifyFolder lowercases every folder in target and turns runs of
+// spaces, underscores and dashes into a single dash, so "My Docs" and
+// "my_docs" both become "my-docs".
+func slugifyFolder(target string) string {
+	parts := strings.Split(filepath.ToSlash(target), "/")
+	for i, part := range parts {
+		words := strings.FieldsFunc(strings.ToLower(part), func(r rune) bool {
+			return r == ' ' || r == '_' || r == '-'
+		})
+		if len(words) > 0 {
+			parts[i] = strings.Join(words, "-")
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// uniquePath returns a path in dir for base that doesn't exist yet and isn't
+// reserved by another move, adding a " - N" suffix before the extension.
+// destMu must be held.
+func uniquePath(dir, base string) string {
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	if name == "" {
+		// dotfiles like ".env" have no extension to preserve
+		name, ext = base, ""
+	}
+
+	for i := 1; ; i++ {
+		newPath := filepath.Join(dir, fmt.Sprintf("%s - %d%s", name, i, ext))
+		if _, err := os.Stat(newPath); os.IsNotExist(err) && !reserved[newPath] {
+			return newPath
+		}
+	}
+}
+
+// fileSize returns the size of path, or 0 if it can't be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func getFileContentSnippet(path string, limit int) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+
+	defer f.Close()
+
+	buf := make([]byte, limit)
+	n, _ := f.Read(buf)
+	return string(buf[:n])
+}
+
+func extractPDFText(path string) string {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	b, err := r.GetPlainText()
+	if err == nil {
+		io.Copy(&buf, b)
+	}
+	text := buf.String()
+	if len(text) > 500 {
+		text = text[:500] + "..."
+	}
+	return text
+}
+
+// processFile runs a single file through the rules and the AI, then hands
+// it to the move workers.
+func processFile(ctx context.Context, path string, config Config) {
+	name := filepath.Base(path)
+
+	if isIgnored(path, watchRootOf(path, config.Options.WatchDirs), config.Ignore) {
+		slog.Info("Ignored file/folder by config: "+name, "event", "ignored", "src", path)
+		return
+	}
+	if config.Options.Mode == "review" && isPending(path, config.Options) {
+		slog.Debug("Already copied for review, waiting for entropy approve: " + name)
+		return
+	}
+	metrics.filesProcessed.Add(1)
+
+	// empty files are placeholders or failed downloads, which neither the
+	// rules nor the AI can tell apart, and would all be duplicates
+	if config.Options.OnEmpty == "skip" || config.Options.OnEmpty == "move" {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			if config.Options.OnEmpty == "skip" {
+				slog.Info("Skipping empty file: "+name, "event", "empty", "src", path)
+				return
+			}
+			slog.Info("Empty file: "+name, "event", "empty", "src", path)
+			queueMove(moveTask{src: path, target: config.Options.EmptyFolder, decidedBy: "empty", opts: config.Options})
+			return
+		}
+	}
+
+	var hash string
+	if hashes != nil {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			if hash, err = hashFile(path); err != nil {
+				slog.Warn(fmt.Sprintf("Could not hash %s: %v", name, err))
+			}
+		}
+	}
+	// the hash is claimed until the move finishes, so an identical file
+	// arriving meanwhile is still seen as a duplicate
+	if hash != "" && !hashes.claim(hash) {
+		if config.Options.OnDuplicate != "move" {
+			slog.Info("Skipping duplicate: "+name, "event", "duplicate", "src", path)
+			return
+		}
+		slog.Info("Duplicate detected: "+name, "event", "duplicate", "src", path)
+		queueMove(moveTask{
+			src:       path,
+			target:    routeTarget(config.Options.DuplicatesFolder, "duplicate", config.Options),
+			decidedBy: "duplicate",
+			opts:      config.Options,
+		})
+		return
+	}
+
+	// executables and scripts skip everything else, so no rule or AI
+	// answer can put them somewhere they might be run from
+	if isQuarantined(name, config.Quarantine) {
+		slog.Warn(fmt.Sprintf("Quarantining %s", name), "event", "quarantined", "src", path, "target", config.Quarantine.Folder)
+		target := routeTarget(config.Quarantine.Folder, "quarantine", config.Options)
+		queueMove(moveTask{src: path, target: target, decidedBy: "quarantine", hash: hash, opts: config.Options})
+		return
+	}
+
+	decidedBy, action, rename := "marker", "", ""
+	var then, hints []string
+	targetFolder := readFolderMarker(path, config.Options.OutputDir)
+	if targetFolder == "" {
+		decidedBy = "extension"
+		if targetFolder = matchExtension(name, config.extensions); targetFolder != "" {
+			slog.Info(fmt.Sprintf("Extension %s matched %s: %s", filepath.Ext(name), name, targetFolder),
+				"event", "extension_matched", "src", path, "target", targetFolder)
+		}
+	}
+	if targetFolder == "" {
+		if match, ok := matchRules(path, config.Rules); ok {
+			for _, i := range match.rules {
+				rule := config.Rules[i]
+				msg := fmt.Sprintf("Rule %d matched %s: %s", rule.number, name, rule.Target)
+				if rule.Target == "" {
+					msg = fmt.Sprintf("Rule %d matched %s", rule.number, name)
+				}
+				slog.Info(msg,
+					"event", "rule_matched", "src", path, "rule", rule.Pattern, "target", rule.Target)
+			}
+			// rules that only add post-actions leave the target to the
+			// next steps
+			if match.rule >= 0 {
+				targetFolder = match.target
+				decidedBy = fmt.Sprintf("rule %d", config.Rules[match.rule].number)
+			}
+			action, rename, then = match.action, match.rename, match.then
+			hints = match.hints
+		}
+	}
+
+	// file_timeout bounds the slow steps below, so a hanging classifier or
+	// model can't hold a file forever
+	decideCtx := ctx
+	if config.Options.fileTimeout > 0 {
+		var cancel context.CancelFunc
+		decideCtx, cancel = context.WithTimeout(ctx, config.Options.fileTimeout)
+		defer cancel()
+	}
+
+	useClassifier := len(config.Classifier.Command) > 0 && !config.Options.RulesOnly
+	if targetFolder == "" && useClassifier && config.Classifier.Order != "after_ai" {
+		if targetFolder = classify(decideCtx, path, config); targetFolder != "" {
+			decidedBy = "classifier"
+		}
+	}
+
+	if targetFolder == "" && config.Gpt.Enabled && decideCtx.Err() == nil {
+		var queued bool
+		targetFolder, queued = askAI(decideCtx, path, strings.Join(hints, " "), config.Gpt)
+		if ctx.Err() != nil {
+			log.Println("Shutting down, leaving in place:", name)
+			hashes.release(hash)
+			return
+		}
+		if !queued {
+			slog.Warn("AI queue is full, leaving in place: "+name, "event", "ai_queue_full", "src", path)
+			hashes.release(hash)
+			return
+		}
+		if targetFolder != "" {
+			decidedBy = "ai"
+			slog.Info("AI suggested folder: "+targetFolder, "event", "ai_suggestion", "src", path, "ai_suggestion", targetFolder)
+		}
+	}
+
+	if targetFolder == "" && useClassifier && config.Classifier.Order == "after_ai" && decideCtx.Err() == nil {
+		if targetFolder = classify(decideCtx, path, config); targetFolder != "" {
+			decidedBy = "classifier"
+		}
+	}
+
+	if targetFolder == "" && decideCtx.Err() != nil && ctx.Err() == nil {
+		slog.Warn(fmt.Sprintf("No target for %s within file_timeout %v, sending to fallback", name, config.Options.fileTimeout),
+			"event", "file_timeout", "src", path)
+	}
+
+	if targetFolder == "" {
+		targetFolder = config.Options.FallbackFolder
+		decidedBy = "fallback"
+	}
+
+	targetFolder = strings.TrimSpace(targetFolder)
+	if config.Options.interactive {
+		confirmed, how, ok := confirmTarget(ctx, path, targetFolder, decidedBy, action, config.Options)
+		if !ok {
+			log.Println("No answer, leaving in place:", name)
+			hashes.release(hash)
+			return
+		}
+		// the rule's action, rename and post-actions only apply to its own
+		// target
+		if how != decidedBy {
+			action, rename, then = "", "", nil
+		}
+		targetFolder, decidedBy = confirmed, how
+	}
+
+	targetFolder = routeTarget(targetFolder, decidedBy, config.Options)
+	queueMove(moveTask{src: path, target: targetFolder, decidedBy: decidedBy, action: action, rename: rename, hash: hash, then: then, opts: config.Options})
+}
+
+// askAI queues path for the AI workers and waits for their answer, or ""
+// once ctx is cancelled and the workers have stopped, or while the AI is
+// disabled, cooling down or outside gpt.active_hours. When the queue is
+// full, gpt.queue_full decides: "block" waits for room, "fallback" gives ""
+// and "reject" gives queued false to leave the file in place. hint is sent
+// along with the file's metadata.
+func askAI(ctx context.Context, path, hint string, cfg GptConfig) (target string, queued bool) {
+	if aiDisabled.Load() || !waitForActiveHours(ctx, path, cfg) {
+		return "", true
+	}
+	allowed, probe := cooldown.allow()
+	if !allowed {
+		return "", true
+	}
+	answered := false
+	defer func() {
+		if !answered {
+			// the file never got an answer, so it can't count as the probe
+			cooldown.abandon(probe)
+		}
+	}()
+	resultCh := make(chan string, 1)
+	job := Job{filename: path, hint: hint, resultCh: resultCh}
+	select {
+	case jobQueue <- job:
+	case <-ctx.Done():
+		return "", true
+	default:
+		if cfg.QueueFull == "fallback" || cfg.QueueFull == "reject" {
+			metrics.queueFull.Add(1)
+			if cfg.QueueFull == "reject" {
+				return "", false
+			}
+			slog.Warn("AI queue is full, sending to fallback: "+filepath.Base(path), "event", "ai_queue_full", "src", path)
+			return "", true
+		}
+		select {
+		case jobQueue <- job:
+		case <-ctx.Done():
+			return "", true
+		}
+	}
+	select {
+	case target := <-resultCh:
+		answered = true
+		cooldown.record(target, probe)
+		return target, true
+	case <-ctx.Done():
+		return "", true
+	}
+}
+
+// queueMove hands task to the move workers, releasing its hash claim if
+// the file is already being moved.
+func queueMove(task moveTask) {
+	if !enqueueMove(task) {
+		slog.Debug("Already moving " + task.src)
+		hashes.release(task.hash)
+	}
+}
+
+// routeTarget applies the route_templates entry for how the target was
+// decided, e.g. turning "Docs" into "ai/Docs" for AI suggestions.
+func routeTarget(target, decidedBy string, opts Options) string {
+	kind, _, _ := strings.Cut(decidedBy, " ")
+	template, ok := opts.RouteTemplates[kind]
+	if !ok || filepath.IsAbs(target) {
+		return target
+	}
+	return strings.ReplaceAll(template, "{target}", target)
+}
+
+// watchRootOf returns the watched folder that contains path, preferring the
+// most specific one, or "" if path isn't under any of them.
+func watchRootOf(path string, dirs []string) string {
+	root := ""
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(dir) > len(root) {
+			root = dir
+		}
+	}
+	return root
+}
+
+// errStalled is returned by waitForStable for files still changing after
+// settle_timeout.
+var errStalled = errors.New("still changing")
+
+// waitForStable polls path until its size and modification time stop
+// changing between two checks, so files still being written aren't moved.
+// It returns errStalled once settle_timeout has passed, or another error
+// when path is gone or ctx is cancelled.
+func waitForStable(ctx context.Context, path string, opts Options) error {
+	deadline := time.Now().Add(opts.settleTimeout)
+	prevSize, prevMod, err := statTree(path)
 	for {
 		select {
-		case event := <-watcher.Events:
-			if event.Op&fsnotify.Create == fsnotify.Create {
+		case <-time.After(opts.settleInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 
-				// skips directories
-				fi, err := os.Stat(event.Name)
-				if err == nil && fi.IsDir() {
-					continue
-				}
+		size, mod, err2 := statTree(path)
+		if err != nil || err2 != nil {
+			// gone already, e.g. a temp file that was renamed
+			return errors.Join(err, err2)
+		}
+		if size == prevSize && mod.Equal(prevMod) {
+			return nil
+		}
+		if opts.settleTimeout > 0 && time.Now().After(deadline) {
+			return errStalled
+		}
+		prevSize, prevMod = size, mod
+	}
+}
 
-				if filepath.Dir(event.Name) != "entropy" {
-					continue
-				}
+// settle waits for path to stop changing and reports whether it should be
+// processed now. Files that never settle are handled per on_stalled.
+func settle(ctx context.Context, path string, config Config) bool {
+	err := waitForStable(ctx, path, config.Options)
+	if !errors.Is(err, errStalled) {
+		return err == nil
+	}
+
+	name, after := filepath.Base(path), config.Options.settleTimeout
+	switch config.Options.OnStalled {
+	case "process":
+		slog.Warn(fmt.Sprintf("Processing %s anyway, still changing after %v", name, after), "event", "stalled", "src", path)
+		return true
+	case "move":
+		slog.Warn(fmt.Sprintf("Moving %s to %s, still changing after %v", name, config.Options.StalledFolder, after), "event", "stalled", "src", path)
+		queueMove(moveTask{src: path, target: config.Options.StalledFolder, decidedBy: "stalled", opts: config.Options})
+	default:
+		slog.Warn(fmt.Sprintf("Leaving %s in place, still changing after %v", name, after), "event", "stalled", "src", path)
+	}
+	return false
+}
 
-				time.Sleep(500 * time.Millisecond)
-				log.Println("New file detected:", event.Name)
+// statTree returns the total size and latest modification time of path,
+// including everything inside it when it's a folder.
+func statTree(path string) (int64, time.Time, error) {
+	var size int64
+	var mod time.Time
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		if info.ModTime().After(mod) {
+			mod = info.ModTime()
+		}
+		return nil
+	})
+	return size, mod, err
+}
 
-				name := filepath.Base(event.Name)
+// isInternalFile reports whether path is one of entropy's own bookkeeping
+// files, which may live inside a watched folder.
+func isInternalFile(path string) bool {
+	name := filepath.Base(path)
+	return name == undoLogName || name == pendingLogName || name == hashIndexName
+}
 
-				if isIgnored(event.Name, config.Ignore) {
-					log.Println("Ignored file/folder by config:", name)
-					continue
-				}
-				targetFolder := matchRules(name, config.Rules)
+// inOutputTree reports whether path is somewhere entropy sorts files into:
+// output_dir itself, a folder below it, or one of the folders it manages
+// like fallback_folder. Files at the top of a watched output_dir are not,
+// as that's where new ones are dropped.
+func inOutputTree(path string, config Config) bool {
+	opts := config.Options
+	var managed []string
+	for _, folder := range []string{opts.FallbackFolder, opts.DuplicatesFolder, config.Quarantine.Folder,
+		opts.TrashFolder, opts.StalledFolder, opts.EmptyFolder} {
+		if folder == "" {
+			continue
+		}
+		if !filepath.IsAbs(folder) {
+			folder = filepath.Join(opts.OutputDir, folder)
+		}
+		managed = append(managed, folder)
+	}
+	if watchRootOf(path, managed) != "" {
+		return true
+	}
+	if path == opts.OutputDir {
+		return true
+	}
+	if watchRootOf(path, []string{opts.OutputDir}) == "" {
+		return false
+	}
+	// a watched folder inside output_dir, e.g. an inbox, isn't sorted into
+	if root := watchRootOf(path, opts.WatchDirs); root != opts.OutputDir && watchRootOf(root, []string{opts.OutputDir}) != "" {
+		return false
+	}
+	return !slices.Contains(opts.WatchDirs, filepath.Dir(path))
+}
 
-				if targetFolder == "" && config.Gpt.Enabled {
-					resultCh := make(chan string, 1)
-					jobQueue <- Job{filename: event.Name, resultCh: resultCh}
-					targetFolder = <-resultCh
-					log.Println("AI suggested folder:", targetFolder)
-				}
+// readFolderMarker returns the target stored in a folder's ".entropy" marker
+// file, or "" if path isn't a folder or has no marker. The marker comes
+// with the dropped folder, so like AI answers it's kept inside outputDir.
+func readFolderMarker(path, outputDir string) string {
+	data, err := os.ReadFile(filepath.Join(path, ".entropy"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			clean, ok := sanitizeFolder(line, outputDir)
+			if !ok {
+				slog.Warn(fmt.Sprintf("Rejected marker folder %q for %s", line, filepath.Base(path)))
+				return ""
+			}
+			return clean
+		}
+	}
+	return ""
+}
 
-				if targetFolder == "" {
-					targetFolder = "Unsorted"
+// scanExisting processes files that were already in dir before the watcher
+// started.
+func scanExisting(ctx context.Context, dir string, config Config) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not scan %s: %v", dir, err))
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.IsDir() || isInternalFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		slog.Info("Existing file found: "+path, "event", "detected", "src", path)
+		processFile(ctx, path, config)
+	}
+}
+
+// watchConfigFiles watches the folders of the config and the files it
+// includes. Adding a folder that's already watched is a no-op.
+func watchConfigFiles(w *fsnotify.Watcher, files []string) error {
+	for _, file := range files {
+		if err := w.Add(filepath.Dir(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rescan catches files whose events the watcher dropped: everything in the
+// watched folders is handled as if it was just created. Top-level folders
+// are handled like new ones, so they're sorted with move_folders on or
+// watched again with recursive on; then the files inside them are handled
+// too. What's already sorted is left alone: the output tree, see
+// inOutputTree, and the folders at the top of a watched output_dir, which
+// can't be told apart from ones dropped there.
+func rescan(ctx context.Context, config Config, watcher *fsnotify.Watcher, watched map[string]bool) {
+	for _, dir := range config.Options.WatchDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Could not rescan %s: %v", dir, err))
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if inOutputTree(path, config) || entry.IsDir() && dir == config.Options.OutputDir {
+				if entry.IsDir() && config.Options.Recursive {
+					if err := watchRecursive(watcher, path); err != nil {
+						slog.Error(fmt.Sprintf("Failed to watch %s: %v", path, err))
+					}
+				}
+				continue
+			}
+			handleEvent(ctx, path, fsnotify.Create, config, watcher, watched)
+			if !entry.IsDir() || !config.Options.Recursive || config.Options.MoveFolders {
+				continue
+			}
+			filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+				switch {
+				case err != nil:
+				case d.IsDir() && inOutputTree(p, config):
+					return filepath.SkipDir
+				case !d.IsDir():
+					handleEvent(ctx, p, fsnotify.Create, config, watcher, watched)
 				}
+				return nil
+			})
+		}
+	}
+}
+
+// warnRestartNeeded logs the settings that are only read at startup and so
+// don't take effect on reload.
+func warnRestartNeeded(prev, next Config) {
+	if strings.Join(prev.Options.WatchDirs, "\x00") != strings.Join(next.Options.WatchDirs, "\x00") ||
+		prev.Options.OutputDir != next.Options.OutputDir || prev.Options.Recursive != next.Options.Recursive ||
+		prev.Options.PollInterval != next.Options.PollInterval || prev.Options.MoveWorkers != next.Options.MoveWorkers {
+		slog.Warn("Restart entropy to apply changes to watched or output folders")
+	}
+	// the rest of gpt is read by the AI workers for each file
+	p, n := prev.Gpt, next.Gpt
+	if p.Enabled != n.Enabled || p.Provider != n.Provider || p.ApiKey != n.ApiKey || p.ApiKeyFile != n.ApiKeyFile ||
+		p.Model != n.Model || p.Instructions != n.Instructions || p.MaxKnowledge != n.MaxKnowledge ||
+		p.RequestsPerMinute != n.RequestsPerMinute || p.Burst != n.Burst || p.Workers != n.Workers ||
+		p.QueueSize != n.QueueSize || p.CacheTTL != n.CacheTTL || p.CacheFile != n.CacheFile ||
+		p.Cooldown != n.Cooldown || p.CooldownRate != n.CooldownRate || p.CooldownWindow != n.CooldownWindow ||
+		prev.Options.KnowledgeBase != next.Options.KnowledgeBase || prev.Options.PreserveStructure != next.Options.PreserveStructure {
+		slog.Warn("Restart entropy to apply changes to gpt settings or the knowledge base")
+	}
+}
+
+// setupPlanOutput enables collecting the dry-run plan for --output json.
+func setupPlanOutput(output string, dryRun bool) {
+	switch output {
+	case "text":
+	case "json":
+		if !dryRun {
+			log.Fatal("--output json needs --dry-run")
+		}
+		plan = &dryRunPlan{}
+	default:
+		log.Fatalf("invalid --output %q: must be text or json", output)
+	}
+}
+
+// truncateKnowledge cuts knowledge down to maxBytes, without splitting a
+// character, so the prompt stays within gpt.max_knowledge. 0 keeps it all.
+func truncateKnowledge(knowledge string, maxBytes int64) string {
+	if maxBytes <= 0 || int64(len(knowledge)) <= maxBytes {
+		return knowledge
+	}
+	slog.Warn(fmt.Sprintf("Knowledge base is %s, only sending the first %s to the AI; raise gpt.max_knowledge to include more",
+		formatSize(int64(len(knowledge))), formatSize(maxBytes)))
+	return strings.ToValidUTF8(knowledge[:maxBytes], "")
+}
+
+// startAI sets up the rate limiter, cache and AI workers when gpt is enabled.
+func startAI(ctx context.Context, config Config, knowledge string, clearCache bool) error {
+	if !config.Gpt.Enabled {
+		return nil
+	}
+	jobQueue = make(chan Job, config.Gpt.QueueSize)
+	limiter = newLimiter(config.Gpt)
+	cooldown = newCooldown(config.Gpt, config.Options.FallbackFolder)
+	if config.Gpt.cacheTTL > 0 {
+		aiCache = newSuggestionCache(config.Gpt.cacheTTL, config.Gpt.CacheFile)
+		if clearCache {
+			aiCache.clear()
+		}
+	}
+	knowledge = truncateKnowledge(knowledge, config.Gpt.maxKnowledge)
+	suggester, err := newSuggester(config.Gpt, knowledge, config.Options.PreserveStructure)
+	if err != nil {
+		return err
+	}
+	aiConfig.Store(&config)
+	suggestFolderWithGenAI(ctx, suggester, config.Gpt.Workers)
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "watch":
+			runWatch(os.Args[2:])
+		case "run":
+			runOnce(os.Args[2:])
+		case "init":
+			runInit(os.Args[2:])
+		case "undo":
+			runUndo(os.Args[2:])
+		case "match":
+			runMatch(os.Args[2:])
+		case "search":
+			runSearch(os.Args[2:])
+		case "approve":
+			runApprove(os.Args[2:])
+		case "doctor":
+			runDoctor(os.Args[2:])
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\nusage: entropy [watch|run|init|undo|approve|match|search|doctor] [flags]\n", os.Args[1])
+			os.Exit(2)
+		}
+		return
+	}
+	runWatch(os.Args[1:])
+}
+
+// handleEvent sorts the file or folder at path after a watcher event, or
+// after polling found it.
+func handleEvent(ctx context.Context, path string, op fsnotify.Op, config Config, watcher *fsnotify.Watcher, watched map[string]bool) {
+	path = filepath.Clean(path)
+	if justWritten.contains(path) || isInternalFile(path) {
+		return
+	}
+	// files that were already sorted would be sorted onto themselves
+	if inOutputTree(path, config) {
+		return
+	}
+
+	// fsnotify can report the same path several times in a row; only the
+	// first event within the debounce window counts, measured from when it
+	// was last handled
+	if recentEvents.contains(path) {
+		slog.Debug("Coalesced duplicate event for " + path)
+		return
+	}
+	recentEvents.add(path, filepath.Dir(path))
+
+	// Rename and Write events often name a file that has already
+	// gone, e.g. one renamed away or sorted a moment ago
+	fi, err := os.Stat(path)
+	if err != nil && !op.Has(fsnotify.Create) {
+		return
+	}
+
+	// folders dropped into a watched root can be sorted as one item,
+	// otherwise they are skipped but watched when recursive
+	if err == nil && fi.IsDir() {
+		if config.Options.MoveFolders && watched[filepath.Dir(path)] {
+			settleInBackground(ctx, path, "folder", config)
+		} else if config.Options.Recursive {
+			if err := watchRecursive(watcher, path); err != nil {
+				slog.Error(fmt.Sprintf("Failed to watch %s: %v", path, err))
+			}
+		}
+		return
+	}
+
+	// only handle files dropped directly into a watched root
+	if !config.Options.Recursive && !watched[filepath.Dir(path)] {
+		return
+	}
+
+	// no point waiting for a download to finish under its temp name
+	if isInProgress(filepath.Base(path), config.Ignore) {
+		slog.Debug("Waiting for download to finish: " + path)
+		return
+	}
+	settleInBackground(ctx, path, "file", config)
+}
+
+var (
+	// settling holds the paths being waited on, so later events for them
+	// don't start another wait
+	settling sync.Map
+	// handling tracks the settleInBackground goroutines for shutdown
+	handling sync.WaitGroup
+)
+
+// settleInBackground waits for path to stop changing and processes it on
+// its own goroutine, so the event loop keeps draining events meanwhile.
+// kind is "file" or "folder", for the log.
+func settleInBackground(ctx context.Context, path, kind string, config Config) {
+	if _, busy := settling.LoadOrStore(path, true); busy {
+		slog.Debug("Already waiting for " + path)
+		return
+	}
+	handling.Add(1)
+	go func() {
+		defer handling.Done()
+		defer settling.Delete(path)
+		// the debounce window counts from when the event was last handled
+		defer recentEvents.add(path, filepath.Dir(path))
+
+		if !settle(ctx, path, config) {
+			return
+		}
+		slog.Info(fmt.Sprintf("New %s detected: %s", kind, path), "event", "detected", "src", path)
+
+		processFile(ctx, path, config)
+		// writes seen while the file settled would sort it again in
+		// copy and dry-run modes, where it stays in place
+		if config.Options.events&fsnotify.Write != 0 {
+			justWritten.add(path, filepath.Dir(path))
+		}
+	}()
+}
+
+// runWatch implements the default "entropy watch" command.
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	watchDir := fs.String("watch-dir", "", "folder to watch, replacing the configured ones")
+	dryRun := fs.Bool("dry-run", false, "log moves without performing them")
+	gpt := fs.Bool("gpt", true, "use the AI for files no rule matches (--gpt=false disables it)")
+	clearCache := fs.Bool("clear-cache", false, "forget cached AI suggestions on startup")
+	output := fs.String("output", "text", `with --dry-run, "json" prints the plan as JSON on exit`)
+	rulesOnly := fs.Bool("rules-only", false, "skip the classifier and AI, sending unmatched files to the fallback folder")
+	interactive := fs.Bool("interactive", false, "ask before sorting each file")
+	fs.Parse(args)
+
+	// only flags given explicitly override the config file
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	applyFlags := func(c *Config) {
+		if set["watch-dir"] {
+			c.Options.WatchDir, c.Options.WatchDirs = *watchDir, nil
+		}
+		if set["dry-run"] {
+			c.Options.DryRun = *dryRun
+		}
+		if set["gpt"] {
+			c.Gpt.Enabled = *gpt
+		}
+		if set["rules-only"] {
+			c.Options.RulesOnly = *rulesOnly
+		}
+		c.Options.interactive = *interactive
+	}
+
+	config, err := loadConfig(*configPath, applyFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	setupLogging(config.Options.LogFormat, config.Options.logLevel)
+	setupPlanOutput(*output, config.Options.DryRun)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	outputDir := config.Options.OutputDir
+	os.MkdirAll(outputDir, config.Options.dirMode)
+
+	knowledge, err := loadKnowledgeBase(config.Options.KnowledgeBase, config.Gpt.maxKnowledge)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Going on without a knowledge base: %v", err))
+	}
+	if config.Options.DetectDuplicates {
+		hashes = loadHashIndex(config.Options.HashIndex)
+	}
+	if config.Options.IndexDB != "" {
+		openIndex(config.Options.IndexDB)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer watcher.Close()
+
+	// the config is watched through its folder since editors often replace
+	// the file instead of writing to it
+	configWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer configWatcher.Close()
+	if err := watchConfigFiles(configWatcher, config.files); err != nil {
+		log.Fatal(err)
+	}
+
+	watched := make(map[string]bool)
+	for _, dir := range config.Options.WatchDirs {
+		os.MkdirAll(dir, config.Options.dirMode)
+		if config.Options.Recursive {
+			err = watchRecursive(watcher, dir)
+		} else {
+			err = watcher.Add(dir)
+		}
+		if err != nil {
+			if config.Options.pollInterval == 0 {
+				log.Fatal(err)
+			}
+			slog.Warn(fmt.Sprintf("Could not watch %s, relying on polling: %v", dir, err))
+		}
+		watched[dir] = true
+	}
+
+	recentEvents.setTTL(config.Options.debounce)
+
+	// polling is a fallback for filesystems that don't deliver events
+	var pollTick <-chan time.Time
+	var poll *poller
+	if config.Options.pollInterval > 0 {
+		poll = newPoller(config.Options)
+		ticker := time.NewTicker(config.Options.pollInterval)
+		defer ticker.Stop()
+		pollTick = ticker.C
+		// sorted files must stay skipped until the next poll has seen them
+		justWritten.setTTL(max(recentTTL, 2*config.Options.pollInterval))
+	}
+
+	if err := startAI(ctx, config, knowledge, *clearCache); err != nil {
+		log.Fatal(err)
+	}
+	startMoveWorkers(config.Options.MoveWorkers)
+	health.setWatchDirs(config.Options.WatchDirs)
+	if config.Options.HTTPAddress != "" {
+		serveHTTP(config.Options.HTTPAddress, config.Options.StatusEndpoints)
+	}
+
+	if config.Options.DryRun {
+		log.Println("Dry-run mode: no files will be moved")
+	}
+	if config.Options.RulesOnly {
+		log.Println("Rules-only mode: unmatched files go to", config.Options.FallbackFolder)
+	}
+
+	if config.Options.ProcessExisting {
+		for _, dir := range config.Options.WatchDirs {
+			scanExisting(ctx, dir, config)
+		}
+	}
+
+	log.Printf("Watching %s...", strings.Join(config.Options.WatchDirs, ", "))
+
+	// dry runs print what they would have done every minute by default
+	interval := config.Options.summaryInterval
+	if interval == 0 && config.Options.DryRun {
+		interval = time.Minute
+	}
+	var summary <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		summary = ticker.C
+	}
+	summaryTitle := "Summary"
+	if config.Options.DryRun {
+		summaryTitle = "Dry-run summary"
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Shutting down...")
+			shutdown()
+			runStats.logSummary(summaryTitle)
+			plan.write(os.Stdout)
+			return
+
+		case event := <-watcher.Events:
+			if event.Op&config.Options.events != 0 {
+				handleEvent(ctx, event.Name, event.Op, config, watcher, watched)
+			}
+
+		case <-pollTick:
+			for _, path := range poll.scan(config.Options) {
+				handleEvent(ctx, path, fsnotify.Create, config, watcher, watched)
+			}
 
-				targetFolder = strings.TrimSpace(targetFolder)
-				organizeItem(event.Name, targetFolder, config.Options.PreserveStructure)
+		case event := <-configWatcher.Events:
+			if !slices.Contains(config.files, filepath.Clean(event.Name)) || !event.Has(fsnotify.Write|fsnotify.Create) {
+				continue
 			}
+			newConfig, err := loadConfig(*configPath, applyFlags)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Keeping previous config, %s is invalid: %v", *configPath, err))
+				continue
+			}
+			warnRestartNeeded(config, newConfig)
+			config = newConfig
+			aiConfig.Store(&newConfig)
+			if err := watchConfigFiles(configWatcher, config.files); err != nil {
+				slog.Error(fmt.Sprintf("Could not watch included configs: %v", err))
+			}
+			logLevel.Set(config.Options.logLevel)
+			recentEvents.setTTL(config.Options.debounce)
+			log.Println("Reloaded", *configPath)
+
+		case err := <-configWatcher.Errors:
+			slog.Error(fmt.Sprint("Config watcher error: ", err))
+
+		case <-summary:
+			runStats.logSummary(summaryTitle)
 
 		case err := <-watcher.Errors:
-			log.Println("Watcher error:", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				slog.Warn("Watcher dropped events, rescanning watched folders", "event", "rescan")
+				rescan(ctx, config, watcher, watched)
+				continue
+			}
+			slog.Error(fmt.Sprint("Watcher error: ", err))
 		}
 	}
 
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// writeFile creates path with content, making its folders as needed.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	writeFile(t, invalid, "options:\n  mode: teleport\n")
+
+	tests := []struct {
+		name, path, want string
+	}{
+		{"missing file", filepath.Join(dir, "missing.yaml"), "missing.yaml"},
+		{"invalid value", invalid, `invalid mode "teleport"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(tt.path, nil)
+			if err == nil {
+				t.Fatal("loadConfig succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q doesn't mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKnowledgeBase(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "knowledge.md")
+	writeFile(t, file, "Invoices go to Finance.")
+	folder := filepath.Join(dir, "kb")
+	writeFile(t, filepath.Join(folder, "work.md"), "Reports go to Work.")
+
+	if got, err := loadKnowledgeBase("", 0); got != "" || err != nil {
+		t.Errorf(`loadKnowledgeBase("") = %q, %v; want "", nil`, got, err)
+	}
+	if got, err := loadKnowledgeBase(file, 0); got != "Invoices go to Finance." || err != nil {
+		t.Errorf("loadKnowledgeBase(file) = %q, %v", got, err)
+	}
+	if got, err := loadKnowledgeBase(folder, 0); !strings.Contains(got, "## work.md") || err != nil {
+		t.Errorf("loadKnowledgeBase(folder) = %q, %v; want a work.md section", got, err)
+	}
+	if _, err := loadKnowledgeBase(filepath.Join(dir, "missing.md"), 0); err == nil {
+		t.Error("loadKnowledgeBase(missing) succeeded, want an error")
+	}
+}
+
+func TestTruncateKnowledge(t *testing.T) {
+	tests := []struct {
+		knowledge string
+		max       int64
+		want      string
+	}{
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 10, "abcdef"},
+		{"abcdef", 3, "abc"},
+		// "é" is two bytes; half of it is dropped
+		{"aé", 2, "a"},
+	}
+	for _, tt := range tests {
+		if got := truncateKnowledge(tt.knowledge, tt.max); got != tt.want {
+			t.Errorf("truncateKnowledge(%q, %d) = %q, want %q", tt.knowledge, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultHashIndex(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "sorted")
+	path := filepath.Join(dir, "rules.yaml")
+	writeFile(t, path, "options:\n  output_dir: "+out+"\n")
+
+	config, err := loadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(out, hashIndexName); config.Options.HashIndex != want {
+		t.Errorf("hash_index = %q, want %q", config.Options.HashIndex, want)
+	}
+	if !isInternalFile(config.Options.HashIndex) {
+		t.Errorf("isInternalFile(%q) = false, want true", config.Options.HashIndex)
+	}
+}
+
+// sortingConfig writes a config watching in/ and sorting *.txt into
+// out/Text, and returns it loaded with the watched folders.
+func sortingConfig(t *testing.T, options string) (Config, map[string]bool) {
+	t.Helper()
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := os.MkdirAll(in, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rules.yaml")
+	writeFile(t, path, "options:\n  watch_dir: "+in+"\n  output_dir: "+out+"\n  settle_interval: \"10ms\"\n"+options+
+		"rules:\n  - pattern: \"\\\\.txt$\"\n    target: \"Text\"\n")
+	config, err := loadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, map[string]bool{in: true}
+}
+
+// undoEntries counts the moves recorded in the undo log of config.
+func undoEntries(t *testing.T, config Config) int {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(config.Options.OutputDir, undoLogName))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestHandleEventDebounce(t *testing.T) {
+	config, watched := sortingConfig(t, "")
+	recentEvents.setTTL(time.Minute)
+	t.Cleanup(func() { recentEvents.setTTL(time.Second) })
+
+	src := filepath.Join(config.Options.WatchDirs[0], "notes.txt")
+	writeFile(t, src, "notes")
+	handleEvent(context.Background(), src, fsnotify.Create, config, nil, watched)
+	handleEvent(context.Background(), src, fsnotify.Write, config, nil, watched)
+	handling.Wait()
+	inflight.Wait()
+
+	if _, err := os.Stat(filepath.Join(config.Options.OutputDir, "Text", "notes.txt")); err != nil {
+		t.Errorf("notes.txt wasn't sorted: %v", err)
+	}
+	if n := undoEntries(t, config); n != 1 {
+		t.Errorf("undo log has %d moves, want 1", n)
+	}
+}
+
+func TestRescanRecursive(t *testing.T) {
+	config, watched := sortingConfig(t, "  recursive: true\n")
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	writeFile(t, filepath.Join(config.Options.WatchDirs[0], "2026", "October", "notes.txt"), "notes")
+	rescan(context.Background(), config, watcher, watched)
+	handling.Wait()
+	inflight.Wait()
+
+	if _, err := os.Stat(filepath.Join(config.Options.OutputDir, "Text", "notes.txt")); err != nil {
+		t.Errorf("nested notes.txt wasn't sorted: %v", err)
+	}
+}
+
+// sortedTree sorts config into its watched folder, as with the default
+// output_dir, and fills it with files that were already sorted there.
+func sortedTree(t *testing.T, config *Config) {
+	t.Helper()
+	in := config.Options.WatchDirs[0]
+	config.Options.OutputDir = in
+	for _, name := range []string{"Text/notes.txt", "Text/notes.txt.meta", "Unsorted/blob.bin", "Duplicates/notes.txt"} {
+		writeFile(t, filepath.Join(in, name), "notes")
+	}
+}
+
+// listTree returns the files under dir, relative to it.
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestHandleEventSkipsSortedFiles(t *testing.T) {
+	config, watched := sortingConfig(t, "  recursive: true\n")
+	sortedTree(t, &config)
+	before := listTree(t, config.Options.OutputDir)
+
+	for _, name := range before {
+		handleEvent(context.Background(), filepath.Join(config.Options.OutputDir, name), fsnotify.Write, config, nil, watched)
+	}
+	handling.Wait()
+	inflight.Wait()
+
+	if after := listTree(t, config.Options.OutputDir); !reflect.DeepEqual(after, before) {
+		t.Errorf("files after events = %q, want %q unchanged", after, before)
+	}
+}
+
+func TestOrganizeItemInPlace(t *testing.T) {
+	config, _ := sortingConfig(t, "")
+	src := filepath.Join(config.Options.OutputDir, "Text", "notes.txt")
+	writeFile(t, src, "notes")
+	original := filepath.Join(config.Options.WatchDirs[0], "report.txt")
+	writeFile(t, original, "report")
+	writeFile(t, filepath.Join(config.Options.OutputDir, "Text", "report.txt"), "report")
+
+	if dest := organizeItem(src, "Text", "rule 1", "move", "", config.Options); dest != "" {
+		t.Errorf("moving a file onto itself gave %s, want it left in place", dest)
+	}
+	if dest := organizeItem(original, "Text", "rule 1", "copy", "", config.Options); dest != "" {
+		t.Errorf("copying a file that's already copied gave %s, want it skipped", dest)
+	}
+	want := []string{filepath.Join("Text", "notes.txt"), filepath.Join("Text", "report.txt")}
+	if got := listTree(t, config.Options.OutputDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("output files = %q, want %q", got, want)
+	}
+}
+
+func TestRescanSortedTree(t *testing.T) {
+	for _, options := range []string{"  move_folders: true\n", "  recursive: true\n", "  recursive: true\n  mode: copy\n"} {
+		t.Run(strings.TrimSpace(options), func(t *testing.T) {
+			config, watched := sortingConfig(t, options)
+			sortedTree(t, &config)
+			before := listTree(t, config.Options.OutputDir)
+			watcher, err := fsnotify.NewWatcher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer watcher.Close()
+
+			rescan(context.Background(), config, watcher, watched)
+			handling.Wait()
+			inflight.Wait()
+
+			if after := listTree(t, config.Options.OutputDir); !reflect.DeepEqual(after, before) {
+				t.Errorf("files after rescan = %q, want %q unchanged", after, before)
+			}
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		file  string
+		want  ruleMatch // ok is expected when want.rules is set
+	}{
+		{
+			name: "first match wins",
+			rules: []Rule{
+				{Pattern: "invoice", Target: "Invoices"},
+				{Pattern: `\.pdf$`, Target: "PDFs"},
+			},
+			file: "invoice.pdf",
+			want: ruleMatch{target: "Invoices", rule: 0, rules: []int{0}},
+		},
+		{
+			name: "priority before file order",
+			rules: []Rule{
+				{Pattern: "invoice", Target: "Invoices"},
+				{Pattern: `\.pdf$`, Target: "PDFs", Priority: 1},
+			},
+			file: "invoice.pdf",
+			want: ruleMatch{target: "PDFs", rule: 0, rules: []int{0}},
+		},
+		{
+			name: "continue chain, last target wins",
+			rules: []Rule{
+				{Pattern: `\.pdf$`, Target: "PDFs", Action: "copy", Then: []string{"meta"}, Continue: true},
+				{Pattern: "nomatch", Target: "Elsewhere"},
+				{Pattern: "invoice", Target: "Invoices", Then: []string{"readonly"}, Continue: true},
+				{Pattern: "2026", Target: "Invoices/2026"},
+				{Pattern: "invoice", Target: "Never"},
+			},
+			file: "invoice-2026.pdf",
+			want: ruleMatch{target: "Invoices/2026", rule: 3, rules: []int{0, 2, 3}, action: "copy", then: []string{"meta", "readonly"}},
+		},
+		{
+			name: "target-less rules only add then and hint",
+			rules: []Rule{
+				{Pattern: `\.pdf$`, Then: []string{"meta"}, Hint: "scanned paperwork", Continue: true},
+				{Pattern: "invoice", Hint: "bills", Continue: true},
+			},
+			file: "invoice.pdf",
+			want: ruleMatch{rule: -1, rules: []int{0, 1}, hints: []string{"scanned paperwork", "bills"}, then: []string{"meta"}},
+		},
+		{
+			name:  "no match",
+			rules: []Rule{{Pattern: "invoice", Target: "Invoices"}},
+			file:  "notes.txt",
+			want:  ruleMatch{rule: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := compileRules(tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := matchRules(filepath.Join(t.TempDir(), tt.file), tt.rules)
+			if wantOK := tt.want.rules != nil; ok != wantOK {
+				t.Errorf("matched = %v, want %v", ok, wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchRules(%s) = %+v, want %+v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
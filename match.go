@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runMatch implements "entropy match <file>...": it prints which rule
+// would pick each file's target, without moving anything. Files don't
+// need to exist, but mime rules only match files that do.
+func runMatch(args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: entropy match [--config rules.yaml] <file>...")
+		os.Exit(2)
+	}
+
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, path := range fs.Args() {
+		fmt.Println(describeMatch(absPath(path), config))
+	}
+}
+
+// describeMatch tells how processFile would decide the target of path.
+func describeMatch(path string, config Config) string {
+	name := filepath.Base(path)
+	if isInternalFile(path) {
+		return name + ": entropy's own file, never sorted"
+	}
+	if isIgnored(path, watchRootOf(path, config.Options.WatchDirs), config.Ignore) {
+		return name + ": ignored by config"
+	}
+	if isQuarantined(name, config.Quarantine) {
+		return fmt.Sprintf("%s: quarantined → %s", name, config.Quarantine.Folder)
+	}
+	if target := readFolderMarker(path, config.Options.OutputDir); target != "" {
+		return fmt.Sprintf("%s: marker file → %s", name, target)
+	}
+	if target := matchExtension(name, config.extensions); target != "" {
+		return fmt.Sprintf("%s: extension_map %s → %s", name, filepath.Ext(name), target)
+	}
+
+	match, ok := matchRules(path, config.Rules)
+	if ok && match.rule >= 0 {
+		rule := config.Rules[match.rule]
+		desc := fmt.Sprintf("%s: rule %d (%s) → %s", name, rule.number, describeRule(rule), match.target)
+		if match.action != "" {
+			desc += ", action " + match.action
+		}
+		if match.rename != "" {
+			desc += ", renamed " + match.rename
+		}
+		return desc
+	}
+
+	next := "fallback " + config.Options.FallbackFolder
+	switch {
+	case len(config.Classifier.Command) > 0:
+		next = "the classifier"
+	case config.Gpt.Enabled:
+		next = "the AI"
+	}
+	if ok {
+		return fmt.Sprintf("%s: rule %d matched without a target, would go to %s", name, config.Rules[match.rules[0]].number, next)
+	}
+	return fmt.Sprintf("%s: no rule matches, would go to %s", name, next)
+}
+
+// describeRule shows what a rule matches on, e.g. `glob "*.iso"`.
+func describeRule(rule Rule) string {
+	kind := "regex"
+	if rule.Type == "glob" {
+		kind = "glob"
+	}
+	var parts []string
+	if rule.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("%s %q", kind, rule.Pattern))
+	}
+	if rule.Mime != "" {
+		parts = append(parts, fmt.Sprintf("mime %q", rule.Mime))
+	}
+	if rule.OlderThan != "" {
+		parts = append(parts, "older than "+rule.OlderThan)
+	}
+	if rule.MinSize != "" {
+		parts = append(parts, "at least "+rule.MinSize)
+	}
+	if rule.MaxSize != "" {
+		parts = append(parts, "at most "+rule.MaxSize)
+	}
+	return strings.Join(parts, ", ")
+}
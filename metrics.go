@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aiLatencyBuckets are the upper bounds, in seconds, of the AI latency
+// histogram.
+var aiLatencyBuckets = []float64{0.25, 0.5, 1, 2, 5, 10, 30, 60}
+
+// metricsRegistry holds the counters exposed on /metrics. Unlike runStats they
+// cover the whole process lifetime.
+type metricsRegistry struct {
+	filesProcessed   atomic.Int64
+	movesSucceeded   atomic.Int64
+	movesFailed      atomic.Int64
+	aiCalls          atomic.Int64
+	aiErrors         atomic.Int64
+	limiterWaits     atomic.Int64
+	limiterWaitNanos atomic.Int64
+	queueFull        atomic.Int64
+
+	mu           sync.Mutex
+	aiLatency    []int64 // cumulative counts per bucket, plus +Inf
+	aiLatencySum float64
+}
+
+var metrics = &metricsRegistry{aiLatency: make([]int64, len(aiLatencyBuckets)+1)}
+
+// observeAICall records one AI request and how long it took.
+func (m *metricsRegistry) observeAICall(took time.Duration, err error) {
+	m.aiCalls.Add(1)
+	if err != nil {
+		m.aiErrors.Add(1)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := took.Seconds()
+	m.aiLatencySum += seconds
+	for i, le := range aiLatencyBuckets {
+		if seconds <= le {
+			m.aiLatency[i]++
+		}
+	}
+	m.aiLatency[len(aiLatencyBuckets)]++
+}
+
+// observeLimiterWait records time spent blocked on the AI rate limiter.
+func (m *metricsRegistry) observeLimiterWait(took time.Duration) {
+	if took < time.Millisecond {
+		return
+	}
+	m.limiterWaits.Add(1)
+	m.limiterWaitNanos.Add(int64(took))
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	counter := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, value)
+	}
+	counter("entropy_files_processed_total", "Files and folders picked up for sorting.", m.filesProcessed.Load())
+	fmt.Fprintf(w, "# HELP entropy_moves_total Moves and copies by result.\n# TYPE entropy_moves_total counter\n")
+	fmt.Fprintf(w, "entropy_moves_total{result=\"success\"} %d\n", m.movesSucceeded.Load())
+	fmt.Fprintf(w, "entropy_moves_total{result=\"failure\"} %d\n", m.movesFailed.Load())
+	counter("entropy_ai_calls_total", "Requests sent to the AI.", m.aiCalls.Load())
+	counter("entropy_ai_errors_total", "AI requests that failed.", m.aiErrors.Load())
+	counter("entropy_ai_queue_full_total", "Files sent to fallback or left in place because the AI queue was full.", m.queueFull.Load())
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("entropy_ai_queue_depth", "Files waiting for the AI workers.", len(jobQueue))
+	gauge("entropy_ai_queue_capacity", "How many files can wait for the AI workers.", cap(jobQueue))
+	counter("entropy_rate_limiter_waits_total", "Times an AI request waited on the rate limiter.", m.limiterWaits.Load())
+	counter("entropy_rate_limiter_wait_seconds_total", "Time spent waiting on the rate limiter.",
+		time.Duration(m.limiterWaitNanos.Load()).Seconds())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP entropy_ai_latency_seconds How long AI requests took.\n# TYPE entropy_ai_latency_seconds histogram\n")
+	for i, le := range aiLatencyBuckets {
+		fmt.Fprintf(w, "entropy_ai_latency_seconds_bucket{le=\"%v\"} %d\n", le, m.aiLatency[i])
+	}
+	count := m.aiLatency[len(aiLatencyBuckets)]
+	fmt.Fprintf(w, "entropy_ai_latency_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "entropy_ai_latency_seconds_sum %v\n", m.aiLatencySum)
+	fmt.Fprintf(w, "entropy_ai_latency_seconds_count %d\n", count)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// moveTask is a file whose target has been decided, waiting to be moved.
+type moveTask struct {
+	src       string
+	target    string
+	decidedBy string
+	action    string
+	rename    string // rename template, see renameBase
+	hash      string
+	then      []string // post-actions, see postActions
+	opts      Options
+}
+
+var (
+	moveQueue chan moveTask
+
+	// queuedMu guards queued, the sources waiting in or being handled by
+	// the pool, so the same file isn't moved twice at once
+	queuedMu sync.Mutex
+	queued   = make(map[string]bool)
+
+	// destMu guards reserved, the destinations claimed by moves still in
+	// progress, so two workers never pick the same free name
+	destMu   sync.Mutex
+	reserved = make(map[string]bool)
+)
+
+// startMoveWorkers starts n goroutines that run organizeItem for tasks sent
+// to moveQueue, so slow copies don't hold up the event loop. n defaults
+// to 4.
+func startMoveWorkers(n int) {
+	if n < 1 {
+		n = 4
+	}
+	moveQueue = make(chan moveTask, n)
+	for range n {
+		go func() {
+			for task := range moveQueue {
+				runMoveTask(task)
+			}
+		}()
+	}
+}
+
+// enqueueMove hands task to the pool, or runs it right away when there's
+// no pool. It returns false if the same file is already queued.
+func enqueueMove(task moveTask) bool {
+	queuedMu.Lock()
+	if queued[task.src] {
+		queuedMu.Unlock()
+		return false
+	}
+	queued[task.src] = true
+	queuedMu.Unlock()
+
+	// counted here rather than in the worker so shutdown also waits for
+	// tasks still sitting in the queue
+	inflight.Add(1)
+	if moveQueue == nil {
+		runMoveTask(task)
+	} else {
+		moveQueue <- task
+	}
+	return true
+}
+
+func runMoveTask(task moveTask) {
+	defer inflight.Done()
+	defer func() {
+		queuedMu.Lock()
+		delete(queued, task.src)
+		queuedMu.Unlock()
+	}()
+
+	dest := organizeItem(task.src, task.target, task.decidedBy, task.action, task.rename, task.opts)
+	if dest != "" && !task.opts.DryRun {
+		runPostActions(task, dest)
+		recordIndex(task, dest)
+	}
+	if task.hash == "" {
+		return
+	}
+	if dest != "" {
+		hashes.add(task.hash)
+	} else {
+		hashes.release(task.hash)
+	}
+}
+
+// reserveDest picks the destination for base in destDir according to
+// onConflict and claims it until releaseDest. ok is false when the file
+// should be skipped.
+func reserveDest(destDir, base, onConflict string) (path string, ok bool) {
+	destMu.Lock()
+	defer destMu.Unlock()
+
+	if strings.Contains(base, counterToken) {
+		path = numberedPath(destDir, base)
+		reserved[path] = true
+		return path, true
+	}
+
+	path = filepath.Join(destDir, base)
+	if _, err := os.Stat(path); err == nil || reserved[path] {
+		switch onConflict {
+		case "skip":
+			return path, false
+		case "overwrite":
+			if reserved[path] {
+				// another worker is writing it right now
+				path = uniquePath(destDir, base)
+			} else {
+				slog.Info("Overwriting " + path)
+			}
+		default:
+			path = uniquePath(destDir, base)
+		}
+	}
+	reserved[path] = true
+	return path, true
+}
+
+func releaseDest(path string) {
+	destMu.Lock()
+	defer destMu.Unlock()
+	delete(reserved, path)
+}
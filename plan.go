@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// planEntry is one file in the dry-run plan printed by --output json.
+type planEntry struct {
+	Src       string `json:"src"`
+	Target    string `json:"target"`
+	DecidedBy string `json:"decided_by"` // "rule N", "extension", "marker", "ai", "fallback" or "duplicate"
+	Action    string `json:"action"`
+}
+
+// dryRunPlan collects what a dry run would do. It's nil unless --output
+// json was given, and its methods are safe on nil.
+type dryRunPlan struct {
+	mu      sync.Mutex
+	entries []planEntry
+}
+
+var plan *dryRunPlan
+
+func (p *dryRunPlan) add(entry planEntry) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = append(p.entries, entry)
+}
+
+// write prints the plan as a JSON array.
+func (p *dryRunPlan) write(w io.Writer) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := p.entries
+	if entries == nil {
+		entries = []planEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
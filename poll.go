@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// poller finds new and changed files by comparing modification times
+// between scans, for filesystems where fsnotify gets no events.
+type poller struct {
+	seen map[string]time.Time
+}
+
+// newPoller remembers what is already in the watched folders, so only
+// files that show up later are reported.
+func newPoller(opts Options) *poller {
+	p := &poller{seen: make(map[string]time.Time)}
+	p.scan(opts)
+	return p
+}
+
+// scan returns the paths that are new or were modified since the last scan.
+func (p *poller) scan(opts Options) []string {
+	current := make(map[string]time.Time)
+	var changed []string
+	visit := func(path string, d fs.DirEntry) {
+		info, err := d.Info()
+		if err != nil {
+			return
+		}
+		mtime := info.ModTime()
+		current[path] = mtime
+		// a folder's mtime changes whenever its contents do, so folders are
+		// only reported when they first appear
+		prev, ok := p.seen[path]
+		if (!ok || !d.IsDir() && !prev.Equal(mtime)) && !justWritten.contains(path) {
+			changed = append(changed, path)
+		}
+	}
+
+	for _, dir := range opts.WatchDirs {
+		if opts.Recursive {
+			filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && path != dir {
+					visit(path, d)
+				}
+				return nil
+			})
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			visit(filepath.Join(dir, entry.Name()), entry)
+		}
+	}
+
+	p.seen = current
+	return changed
+}
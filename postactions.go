@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// postActions are the built-ins a rule can list under "then", run after
+// the file reached its destination.
+var postActions = map[string]func(task moveTask, dest string) error{
+	"meta":     writeMetaSidecar,
+	"readonly": makeReadOnly,
+}
+
+// runPostActions runs task's "then" list for the file now at dest. A failing
+// action is logged and doesn't stop the others.
+func runPostActions(task moveTask, dest string) {
+	for _, name := range task.then {
+		if err := postActions[name](task, dest); err != nil {
+			slog.Error(fmt.Sprintf("Post-action %s failed for %s: %v", name, filepath.Base(dest), err),
+				"event", "post_action_failed", "src", task.src, "dest", dest, "action", name, "error", err)
+		}
+	}
+}
+
+// metaSidecar is what "meta" writes next to the sorted file.
+type metaSidecar struct {
+	OriginalName string    `json:"original_name"`
+	OriginalPath string    `json:"original_path"`
+	ModTime      time.Time `json:"mod_time"`
+	SortedAt     time.Time `json:"sorted_at"`
+	DecidedBy    string    `json:"decided_by"`
+}
+
+// writeMetaSidecar records where dest came from in "<dest>.meta".
+func writeMetaSidecar(task moveTask, dest string) error {
+	meta := metaSidecar{
+		OriginalName: filepath.Base(task.src),
+		OriginalPath: task.src,
+		SortedAt:     time.Now(),
+		DecidedBy:    task.decidedBy,
+	}
+	if info, err := os.Stat(dest); err == nil {
+		meta.ModTime = info.ModTime()
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := dest + ".meta"
+	justWritten.add(path, task.opts.OutputDir)
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// makeReadOnly clears the write bits of dest, e.g. for archived documents.
+// Links from action "symlink" are skipped, as chmod would change the
+// original they point to.
+func makeReadOnly(_ moveTask, dest string) error {
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		slog.Warn(fmt.Sprintf("Not making %s read-only, it's a link to the original", filepath.Base(dest)))
+		return nil
+	}
+	return os.Chmod(dest, info.Mode().Perm()&^0o222)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakeReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original.txt")
+	writeFile(t, original, "notes")
+	link := filepath.Join(dir, "link.txt")
+	if err := symlinkPath(original, link); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(dir, "copy.txt")
+	writeFile(t, copied, "notes")
+	for _, path := range []string{original, copied} {
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dest := range []string{link, copied} {
+		if err := makeReadOnly(moveTask{}, dest); err != nil {
+			t.Fatalf("makeReadOnly(%s): %v", dest, err)
+		}
+	}
+	for path, want := range map[string]os.FileMode{original: 0o644, copied: 0o444} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("mode of %s = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// QuarantineConfig sends executables and scripts to a folder of their own,
+// ahead of the rules and the AI.
+type QuarantineConfig struct {
+	Extensions []string `yaml:"extensions"` // e.g. ".exe", ".sh"; empty disables quarantine
+	Folder     string   `yaml:"folder"`     // defaults to "Quarantine"
+	Allow      []string `yaml:"allow"`      // file names or globs sorted as usual
+
+	extensions map[string]bool // lowercased ".ext"
+}
+
+// normalizeExt lowercases ext and adds the leading dot if it's missing.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// isQuarantined reports whether the file name has a quarantined extension
+// and isn't allowed explicitly.
+func isQuarantined(name string, cfg QuarantineConfig) bool {
+	if !cfg.extensions[strings.ToLower(filepath.Ext(name))] {
+		return false
+	}
+	for _, allow := range cfg.Allow {
+		if ok, _ := filepath.Match(allow, name); ok || allow == name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recentTTL is how long events for a path entropy wrote are ignored.
+const recentTTL = time.Minute
+
+// recentPaths remembers paths entropy itself just wrote, so the watcher
+// events they cause don't get them sorted again. Entries expire after ttl.
+type recentPaths struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	paths map[string]time.Time
+}
+
+func newRecentPaths(ttl time.Duration) *recentPaths {
+	return &recentPaths{ttl: ttl, paths: make(map[string]time.Time)}
+}
+
+// setTTL changes how long entries are kept, e.g. on config reload while
+// events are being handled.
+func (r *recentPaths) setTTL(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ttl = ttl
+}
+
+// add records path and every folder between it and root.
+func (r *recentPaths) add(path, root string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for p, at := range r.paths {
+		if now.Sub(at) > r.ttl {
+			delete(r.paths, p)
+		}
+	}
+	for p := filepath.Clean(path); p != root && p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		r.paths[p] = now
+	}
+}
+
+// contains reports whether path was written less than ttl ago.
+func (r *recentPaths) contains(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path = filepath.Clean(path)
+	at, ok := r.paths[path]
+	if ok && time.Since(at) > r.ttl {
+		delete(r.paths, path)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// counterToken in a rename template is replaced by the lowest number that
+// gives a free name in the target folder, see reserveDest.
+const counterToken = "{counter}"
+
+// renameBase expands the rename template of a rule into the new base name
+// for path, e.g. "{date}_{name}.{ext}" gives "2024-03-15_invoice.pdf".
+// {counter} is left in for reserveDest. Characters that can't be in a
+// filename are dropped; if nothing usable is left, or unresolved_tokens is
+// "unsorted" and a token is unknown, the file keeps its name.
+func renameBase(template, path string, opts Options) string {
+	base := filepath.Base(path)
+	pairs, err := fileTokens(template, path)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not read date of %s: %v", path, err))
+		return base
+	}
+	if filepath.Ext(base) == "" {
+		// "README" has no {ext}, so it isn't renamed to "2024-03-15_README."
+		template = strings.ReplaceAll(strings.ReplaceAll(template, ".{ext}", ""), "{ext}", "")
+	}
+	expanded := strings.NewReplacer(pairs...).Replace(template)
+	if left := tokenPattern.FindString(strings.ReplaceAll(expanded, counterToken, "")); left != "" && opts.UnresolvedTokens == "unsorted" {
+		slog.Warn(fmt.Sprintf("Unresolved token %s in rename %q for %s, keeping its name", left, template, base))
+		return base
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' || strings.ContainsRune(illegalPathChars, r) {
+			return -1
+		}
+		return r
+	}, expanded)
+	// Windows also drops trailing dots and spaces
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" || name == counterToken {
+		slog.Warn(fmt.Sprintf("Rename %q gives no usable name for %s, keeping its name", template, base))
+		return base
+	}
+	return name
+}
+
+// numberedPath returns the path in dir for base with {counter} replaced by
+// the lowest number from 1 that doesn't exist and isn't reserved. destMu
+// must be held.
+func numberedPath(dir, base string) string {
+	for i := 1; ; i++ {
+		path := filepath.Join(dir, strings.ReplaceAll(base, counterToken, strconv.Itoa(i)))
+		if _, err := os.Stat(path); os.IsNotExist(err) && !reserved[path] {
+			return path
+		}
+	}
+}
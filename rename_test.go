@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenameBase(t *testing.T) {
+	dir := t.TempDir()
+	mod := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	for _, name := range []string{"invoice.PDF", "README"} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, "x")
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		template, file, want string
+	}{
+		{"{date}_{name}.{ext}", "invoice.PDF", "2026-10-14_invoice.pdf"},
+		{"{date}_{name}.{ext}", "README", "2026-10-14_README"},
+		{"{ext}", "README", "README"},
+		{"{name}_{counter}.{ext}", "invoice.PDF", "invoice_{counter}.pdf"},
+	}
+	for _, tt := range tests {
+		if got := renameBase(tt.template, filepath.Join(dir, tt.file), Options{}); got != tt.want {
+			t.Errorf("renameBase(%q, %s) = %q, want %q", tt.template, tt.file, got, tt.want)
+		}
+	}
+}
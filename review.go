@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pendingLogName = ".entropy-pending.jsonl"
+
+// pendingEntry is one line of the pending log: a file copied in review
+// mode whose original waits for "entropy approve". Size and ModTime are
+// the original's, so it isn't deleted if it changed since.
+type pendingEntry struct {
+	Src     string    `json:"src"`
+	Dest    string    `json:"dest"`
+	Time    time.Time `json:"time"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+var (
+	pendingMu sync.Mutex
+	// pendingSrcs are the originals in the pending log as of pendingStat,
+	// the log's size and mtime when it was read
+	pendingSrcs map[string]bool
+	pendingStat struct {
+		size    int64
+		modTime time.Time
+	}
+)
+
+func pendingLogPath(opts Options) string {
+	return filepath.Join(opts.OutputDir, pendingLogName)
+}
+
+// recordPending appends a review copy of src to dest to the pending log.
+func recordPending(opts Options, src, dest string) {
+	entry := pendingEntry{Src: src, Dest: dest, Time: time.Now()}
+	if info, err := os.Stat(src); err == nil {
+		entry.Size, entry.ModTime = info.Size(), info.ModTime()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	path := pendingLogPath(opts)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not update pending log %s: %v", path, err))
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// isPending reports whether path was already copied in review mode and
+// waits for approval, so it isn't copied again.
+func isPending(path string, opts Options) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	loadPendingLocked(opts)
+	return pendingSrcs[path]
+}
+
+// loadPendingLocked reads the pending log again whenever it has changed,
+// e.g. after "entropy approve" ran in another process. pendingMu must be
+// held.
+func loadPendingLocked(opts Options) {
+	path := pendingLogPath(opts)
+	var size int64
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		size, modTime = info.Size(), info.ModTime()
+	}
+	if pendingSrcs != nil && size == pendingStat.size && modTime.Equal(pendingStat.modTime) {
+		return
+	}
+	pendingSrcs = make(map[string]bool)
+	pendingStat.size, pendingStat.modTime = size, modTime
+	entries, err := readPendingLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn(fmt.Sprintf("Could not read pending log: %v", err))
+	}
+	for _, entry := range entries {
+		pendingSrcs[entry.Src] = true
+	}
+}
+
+func readPendingLog(path string) ([]pendingEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []pendingEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry pendingEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn(fmt.Sprintf("Skipping invalid pending log line: %v", err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func writePendingLog(path string, entries []pendingEntry) error {
+	var buf []byte
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+// approveOne deletes the original of entry, once its copy is still there
+// and the original hasn't changed since it was copied.
+func approveOne(entry pendingEntry, dryRun bool) error {
+	if _, err := os.Stat(entry.Dest); err != nil {
+		return fmt.Errorf("copy is gone: %w", err)
+	}
+	info, err := os.Stat(entry.Src)
+	if os.IsNotExist(err) {
+		// already removed by hand, nothing left to do
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() && (info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime)) {
+		return fmt.Errorf("original has changed since it was copied")
+	}
+	if dryRun {
+		log.Printf("Would remove original %s", entry.Src)
+		return nil
+	}
+	return os.RemoveAll(entry.Src)
+}
+
+// matchesPending reports whether entry is selected by one of paths: its
+// original, its copy or a folder containing either.
+func matchesPending(entry pendingEntry, paths []string) bool {
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		for _, p := range []string{entry.Src, entry.Dest} {
+			if rel, err := filepath.Rel(abs, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runApprove implements "entropy approve": it deletes the originals of
+// files copied in review mode, for the given files or folders or with
+// --all for everything. Without either it lists what's pending.
+func runApprove(args []string) {
+	fs := flag.NewFlagSet("approve", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	all := fs.Bool("all", false, "approve every pending copy")
+	dryRun := fs.Bool("dry-run", false, "show which originals would be removed without removing them")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path := pendingLogPath(config.Options)
+	entries, err := readPendingLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Could not read pending log: %v", err)
+	}
+	if len(entries) == 0 {
+		log.Println("Nothing is waiting for approval")
+		return
+	}
+	if !*all && fs.NArg() == 0 {
+		for _, entry := range entries {
+			fmt.Printf("%s → %s\n", entry.Src, entry.Dest)
+		}
+		fmt.Fprintln(os.Stderr, "approve with: entropy approve --all, or entropy approve <file or folder>...")
+		return
+	}
+
+	approved := make(map[int]bool)
+	count := 0
+	for i, entry := range entries {
+		if !*all && !matchesPending(entry, fs.Args()) {
+			continue
+		}
+		count++
+		if err := approveOne(entry, *dryRun); err != nil {
+			log.Printf("Skipping %s: %v", entry.Src, err)
+			continue
+		}
+		if !*dryRun {
+			// the file is now moved, so undo can put it back
+			recordUndo(config.Options, entry.Src, entry.Dest, "move")
+			log.Printf("Approved %s → %s", entry.Src, entry.Dest)
+		}
+		approved[i] = true
+	}
+
+	if *dryRun || len(approved) == 0 {
+		log.Printf("%d of %d pending copies can be approved", len(approved), count)
+		return
+	}
+
+	var remaining []pendingEntry
+	for i, entry := range entries {
+		if !approved[i] {
+			remaining = append(remaining, entry)
+		}
+	}
+	if err := writePendingLog(path, remaining); err != nil {
+		log.Fatalf("Could not update pending log: %v", err)
+	}
+	log.Printf("Approved %d of %d pending copies", len(approved), count)
+}
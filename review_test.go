@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsPendingSeesApprove(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{OutputDir: dir}
+	src := filepath.Join(dir, "report.pdf")
+	writeFile(t, src, "report")
+	t.Cleanup(func() { pendingSrcs = nil })
+
+	recordPending(opts, src, filepath.Join(dir, "Work", "report.pdf"))
+	if !isPending(src, opts) {
+		t.Fatal("isPending = false right after recordPending")
+	}
+
+	// "entropy approve" in another process empties the log
+	if err := writePendingLog(pendingLogPath(opts), nil); err != nil {
+		t.Fatal(err)
+	}
+	if isPending(src, opts) {
+		t.Error("isPending = true after the log was emptied")
+	}
+
+	os.Remove(pendingLogPath(opts))
+	if isPending(src, opts) {
+		t.Error("isPending = true after the log was removed")
+	}
+}
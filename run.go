@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runOnce implements "entropy run --once <dir>": it organizes what is in
+// dir right now and exits instead of watching it.
+func runOnce(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	once := fs.Bool("once", false, "organize the folder's current contents and exit")
+	dryRun := fs.Bool("dry-run", false, "log moves without performing them")
+	gpt := fs.Bool("gpt", true, "use the AI for files no rule matches (--gpt=false disables it)")
+	clearCache := fs.Bool("clear-cache", false, "forget cached AI suggestions on startup")
+	output := fs.String("output", "text", `with --dry-run, "json" prints the plan as JSON instead of a summary`)
+	rulesOnly := fs.Bool("rules-only", false, "skip the classifier and AI, sending unmatched files to the fallback folder")
+	interactive := fs.Bool("interactive", false, "ask before sorting each file")
+	fs.Parse(args)
+
+	if !*once || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: entropy run --once [flags] <dir>")
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	config, err := loadConfig(*configPath, func(c *Config) {
+		c.Options.WatchDir, c.Options.WatchDirs = dir, nil
+		if set["dry-run"] {
+			c.Options.DryRun = *dryRun
+		}
+		if set["gpt"] {
+			c.Gpt.Enabled = *gpt
+		}
+		if set["rules-only"] {
+			c.Options.RulesOnly = *rulesOnly
+		}
+		c.Options.interactive = *interactive
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir = config.Options.WatchDirs[0]
+
+	setupLogging(config.Options.LogFormat, config.Options.logLevel)
+	setupPlanOutput(*output, config.Options.DryRun)
+
+	if _, err := os.Stat(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.MkdirAll(config.Options.OutputDir, config.Options.dirMode)
+
+	knowledge, err := loadKnowledgeBase(config.Options.KnowledgeBase, config.Gpt.maxKnowledge)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Going on without a knowledge base: %v", err))
+	}
+	if config.Options.DetectDuplicates {
+		hashes = loadHashIndex(config.Options.HashIndex)
+	}
+	if config.Options.IndexDB != "" {
+		openIndex(config.Options.IndexDB)
+	}
+	if err := startAI(ctx, config, knowledge, *clearCache); err != nil {
+		log.Fatal(err)
+	}
+	startMoveWorkers(config.Options.MoveWorkers)
+
+	summaryTitle := "Summary"
+	if config.Options.DryRun {
+		log.Println("Dry-run mode: no files will be moved")
+		summaryTitle = "Dry-run summary"
+	}
+	if config.Options.RulesOnly {
+		log.Println("Rules-only mode: unmatched files go to", config.Options.FallbackFolder)
+	}
+
+	log.Printf("Organizing %s...", dir)
+	scanExisting(ctx, dir, config)
+	shutdown()
+	if plan != nil {
+		plan.write(os.Stdout)
+		return
+	}
+	runStats.logSummary(summaryTitle)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stats collects what happened to the files seen in this run, for the
+// summary printed periodically and on shutdown.
+type stats struct {
+	mu         sync.Mutex
+	byTarget   map[string]int
+	byDecision map[string]int
+	files      int
+	bytes      int64
+	failed     int
+	aiCalls    int
+	aiErrors   int
+	changed    bool
+}
+
+var runStats = newStats()
+
+func newStats() *stats {
+	return &stats{byTarget: make(map[string]int), byDecision: make(map[string]int)}
+}
+
+// recordOrganized counts a file sent to target. decidedBy is how the target
+// was picked, e.g. "rule 2", "ai" or "fallback"; rules are grouped together.
+func (s *stats) recordOrganized(target, decidedBy string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kind, _, _ := strings.Cut(decidedBy, " ")
+	s.byTarget[target]++
+	s.byDecision[kind]++
+	s.files++
+	s.bytes += size
+	s.changed = true
+}
+
+func (s *stats) recordFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed++
+	s.changed = true
+}
+
+func (s *stats) recordAICall(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aiCalls++
+	if err != nil {
+		s.aiErrors++
+	}
+	s.changed = true
+}
+
+// logSummary prints a table of the counts, unless nothing changed since the
+// last one.
+func (s *stats) logSummary(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.changed {
+		return
+	}
+	s.changed = false
+
+	targets := make([]string, 0, len(s.byTarget))
+	for t := range s.byTarget {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+
+	log.Println(title + ":")
+	for _, t := range targets {
+		log.Printf("  %-40s %d file(s)", t, s.byTarget[t])
+	}
+
+	decisions := make([]string, 0, len(s.byDecision))
+	for _, d := range []string{"quarantine", "rule", "extension", "marker", "classifier", "ai", "manual", "fallback", "duplicate", "stalled", "empty"} {
+		if n := s.byDecision[d]; n > 0 {
+			decisions = append(decisions, fmt.Sprintf("%s %d", d, n))
+		}
+	}
+	log.Printf("  Decided by: %s", strings.Join(decisions, ", "))
+	log.Printf("  Total: %d file(s), %s, %d failed; AI calls: %d (%d failed)",
+		s.files, formatSize(s.bytes), s.failed, s.aiCalls, s.aiErrors)
+}
+
+// formatSize renders a byte count using the same 1024-based units as
+// parseSize.
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthState is what /status reports besides the queue.
+type healthState struct {
+	mu          sync.Mutex
+	started     time.Time
+	watchDirs   []string
+	lastError   string
+	lastErrorAt time.Time
+}
+
+var health = &healthState{started: time.Now()}
+
+func (h *healthState) setWatchDirs(dirs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watchDirs = dirs
+}
+
+func (h *healthState) setLastError(at time.Time, msg string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError, h.lastErrorAt = msg, at
+}
+
+type statusReport struct {
+	Uptime      string     `json:"uptime"`
+	WatchDirs   []string   `json:"watch_dirs"`
+	QueueDepth  int        `json:"queue_depth"`
+	QueueSize   int        `json:"queue_capacity"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+func (h *healthState) serveStatus(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	report := statusReport{
+		Uptime:     time.Since(h.started).Round(time.Second).String(),
+		WatchDirs:  h.watchDirs,
+		QueueDepth: len(jobQueue),
+		QueueSize:  cap(jobQueue),
+		LastError:  h.lastError,
+	}
+	if !h.lastErrorAt.IsZero() {
+		at := h.lastErrorAt
+		report.LastErrorAt = &at
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// serveHTTP starts the optional listener for /metrics and, when status is
+// set, /healthz and /status. A listener that fails is logged but doesn't
+// stop entropy.
+func serveHTTP(addr string, status bool) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	if status {
+		mux.HandleFunc("/healthz", serveHealthz)
+		mux.HandleFunc("/status", health.serveStatus)
+	}
+
+	log.Printf("Serving metrics on http://%s", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error(fmt.Sprintf("HTTP listener on %s stopped: %v", addr, err))
+		}
+	}()
+}
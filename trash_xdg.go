@@ -0,0 +1,67 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// moveToTrash moves path into the XDG trash in the user's home, writing
+// the .trashinfo file desktop environments use to restore it.
+func moveToTrash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	trash := filepath.Join(dataHome, "Trash")
+	filesDir, infoDir := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0o700); err != nil {
+		return err
+	}
+
+	// the info file is created exclusively to claim a free name
+	name := filepath.Base(abs)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	var info *os.File
+	for i := 1; ; i++ {
+		info, err = os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+	}
+
+	escaped := (&url.URL{Path: abs}).EscapedPath()
+	_, err = fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", escaped, time.Now().Format("2006-01-02T15:04:05"))
+	if cerr := info.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = moveFile(abs, filepath.Join(filesDir, name))
+	}
+	if err != nil {
+		os.Remove(filepath.Join(infoDir, name+".trashinfo"))
+	}
+	return err
+}
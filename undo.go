@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const undoLogName = ".entropy-undo.jsonl"
+
+// undoEntry is one line of the undo log, written after every successful
+// move or copy.
+type undoEntry struct {
+	Src     string    `json:"src"`
+	Dest    string    `json:"dest"`
+	Mode    string    `json:"mode"`
+	Time    time.Time `json:"time"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+var undoMu sync.Mutex
+
+func undoLogPath(opts Options) string {
+	return filepath.Join(opts.OutputDir, undoLogName)
+}
+
+// recordUndo appends a finished move of src to dest to the undo log.
+func recordUndo(opts Options, src, dest, mode string) {
+	entry := undoEntry{Src: src, Dest: dest, Mode: mode, Time: time.Now()}
+	if info, err := os.Stat(dest); err == nil {
+		entry.Size, entry.ModTime = info.Size(), info.ModTime()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	undoMu.Lock()
+	defer undoMu.Unlock()
+
+	path := undoLogPath(opts)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not update undo log %s: %v", path, err))
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+func readUndoLog(path string) ([]undoEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []undoEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry undoEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn(fmt.Sprintf("Skipping invalid undo log line: %v", err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func writeUndoLog(path string, entries []undoEntry) error {
+	var buf []byte
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+// undoOne reverses a single entry. Entries whose destination has changed
+// since, or whose source path is taken again, are skipped.
+func undoOne(entry undoEntry, dirMode os.FileMode, dryRun bool) error {
+	info, err := os.Stat(entry.Dest)
+	if err != nil {
+		return fmt.Errorf("destination is gone: %w", err)
+	}
+	if !info.IsDir() && (info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime)) {
+		return fmt.Errorf("destination has changed since it was sorted")
+	}
+
+	if entry.Mode == "copy" {
+		if dryRun {
+			log.Printf("Would remove copy %s", entry.Dest)
+			return nil
+		}
+		return os.RemoveAll(entry.Dest)
+	}
+	if entry.Mode == "symlink" {
+		if dryRun {
+			log.Printf("Would remove link %s", entry.Dest)
+			return nil
+		}
+		return os.Remove(entry.Dest)
+	}
+
+	if _, err := os.Stat(entry.Src); err == nil {
+		return fmt.Errorf("%s exists again", entry.Src)
+	}
+	if dryRun {
+		log.Printf("Would move %s → %s", entry.Dest, entry.Src)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.Src), dirMode); err != nil {
+		return err
+	}
+	return moveFile(entry.Dest, entry.Src)
+}
+
+// runUndo implements "entropy undo": it walks the undo log backwards and
+// puts files back where they came from.
+func runUndo(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	configPath := fs.String("config", "rules.yaml", "path to the config file")
+	last := fs.Int("last", 0, "only undo the most recent N entries (0 undoes all)")
+	since := fs.Duration("since", 0, "only undo entries newer than this, e.g. 2h")
+	dryRun := fs.Bool("dry-run", false, "show what would be undone without doing it")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path := undoLogPath(config.Options)
+	entries, err := readUndoLog(path)
+	if err != nil {
+		log.Fatalf("Could not read undo log: %v", err)
+	}
+
+	undone := make(map[int]bool)
+	count := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if *last > 0 && count >= *last {
+			break
+		}
+		if *since > 0 && time.Since(entry.Time) > *since {
+			break
+		}
+		count++
+
+		if err := undoOne(entry, config.Options.dirMode, *dryRun); err != nil {
+			log.Printf("Skipping %s: %v", entry.Dest, err)
+			continue
+		}
+		if !*dryRun {
+			log.Printf("Restored %s → %s", entry.Dest, entry.Src)
+		}
+		undone[i] = true
+	}
+
+	if *dryRun || len(undone) == 0 {
+		log.Printf("%d of %d entries can be undone", len(undone), count)
+		return
+	}
+
+	var remaining []undoEntry
+	for i, entry := range entries {
+		if !undone[i] {
+			remaining = append(remaining, entry)
+		}
+	}
+	if err := writeUndoLog(path, remaining); err != nil {
+		log.Fatalf("Could not update undo log: %v", err)
+	}
+	log.Printf("Undid %d of %d entries", len(undone), count)
+}